@@ -2,6 +2,8 @@
 
 binDir="./bin"
 mainDir="./cmd"
+version=$(git describe --tags --always --dirty 2>/dev/null || echo "dev")
+ldflags="-X github.com/apudiu/server-backup/internal/util.Version=$version"
 
 if [[ ! -d "$binDir" ]]; then
   mkdir $binDir
@@ -9,22 +11,22 @@ fi
 
 echo "Building - Windows amd64"
 # Windows amd64 build
-env GOOS=windows GOARCH=amd64 go build -o $binDir/server-backup-win-amd64.exe $mainDir
+env GOOS=windows GOARCH=amd64 go build -ldflags "$ldflags" -o $binDir/server-backup-win-amd64.exe $mainDir
 
 echo "Building - Linux amd64"
 # Linux amd64 build
-env GOOS=linux GOARCH=amd64 go build -o $binDir/server-backup-linux-amd64 $mainDir
+env GOOS=linux GOARCH=amd64 go build -ldflags "$ldflags" -o $binDir/server-backup-linux-amd64 $mainDir
 
 echo "Building - Linux arm64"
 # Linux arm64 build
-env GOOS=linux GOARCH=arm64 go build -o $binDir/server-backup-linux-arm64 $mainDir
+env GOOS=linux GOARCH=arm64 go build -ldflags "$ldflags" -o $binDir/server-backup-linux-arm64 $mainDir
 
 echo "Building - Mac amd64"
 # Mac amd64 build
-env GOOS=darwin GOARCH=amd64 go build -o $binDir/server-backup-mac-amd64 $mainDir
+env GOOS=darwin GOARCH=amd64 go build -ldflags "$ldflags" -o $binDir/server-backup-mac-amd64 $mainDir
 
 echo "Building - Mac arm64"
 # Mac arm64 build
-env GOOS=darwin GOARCH=arm64 go build -o $binDir/server-backup-mac-arm64 $mainDir
+env GOOS=darwin GOARCH=arm64 go build -ldflags "$ldflags" -o $binDir/server-backup-mac-arm64 $mainDir
 
 echo "Completed"
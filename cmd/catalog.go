@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/catalog"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/remotebackup"
+	"github.com/apudiu/server-backup/internal/report"
+	"github.com/apudiu/server-backup/internal/util"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	catalogOnce sync.Once
+	cat         *catalog.Catalog
+)
+
+// backupCatalog returns the catalog kept in backup dir, shared by all goroutines of the process
+func backupCatalog() *catalog.Catalog {
+	catalogOnce.Do(func() {
+		cat = catalog.Open(util.BackupDir + util.DS + "catalog.jsonl")
+	})
+	return cat
+}
+
+// catalogBackups are backup dirs known to catalog, read once per run & shared by retention of its projects
+type catalogBackups struct {
+	backups map[string]*catalog.Backup
+	err     error
+}
+
+// readCatalogBackups reads backup dirs of all projects from the catalog
+func readCatalogBackups() *catalogBackups {
+	backups, err := backupCatalog().Backups("", "")
+	return &catalogBackups{backups: backups, err: err}
+}
+
+// catalogRetention returns backup dirs of the project known to catalog @cb which still exist locally, or in s3
+// when @s3Enabled, & a func telling whether a backup dir is good. Dirs which never existed (like of runs failed
+// to connect) or are gone are dropped. Dirs unknown to catalog (taken before it existed) are taken as good
+func catalogRetention(
+	cb *catalogBackups,
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	s3Enabled bool,
+	l *logger.Logger,
+) ([]string, func(dir string) bool) {
+	if cb.err != nil {
+		l.AddHeader("Catalog read err, using local backups only. " + cb.err.Error())
+		return nil, func(string) bool { return true }
+	}
+
+	var known, notLocal []string
+	for dir, b := range cb.backups {
+		if b.Server != sc.Id() || b.Project != pc.Path {
+			continue
+		}
+
+		if exist, _ := util.IsPathExist(dir); exist {
+			known = append(known, dir)
+		} else if s3Enabled && !b.RemoteDeleted {
+			notLocal = append(notLocal, dir)
+		}
+	}
+
+	// bucket is listed only when some dirs may be left there
+	if len(notLocal) > 0 {
+		inS3, err := s3BackupDirs(sc, pc, l)
+		if err != nil {
+			l.AddHeader("Bucket listing err, taking backups known to catalog as kept in s3. " + err.Error())
+		}
+		for _, dir := range notLocal {
+			if err != nil || inS3[dir] {
+				known = append(known, dir)
+			}
+		}
+	}
+
+	good := func(dir string) bool {
+		b, ok := cb.backups[dir]
+		return !ok || b.Good
+	}
+
+	return known, good
+}
+
+// s3BackupDirs returns backup dirs of the project (cleaned local paths) having objects in the bucket
+func s3BackupDirs(sc *config.ServerConfig, pc *config.ProjectConfig, l *logger.Logger) (map[string]bool, error) {
+	rb, err := remotebackup.New(sc.S3User, sc.S3Bucket, sc.DestPath(), 10, l)
+	if err != nil {
+		return nil, err
+	}
+
+	projectDir := filepath.Dir(pc.DestPath(sc))
+	objects, err := rb.ListObjectsWithPrefix(remotebackup.ObjectKey(projectDir) + util.DS)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := map[string]bool{}
+	for _, o := range objects {
+		if o.Key != nil {
+			dirs[filepath.Dir(*o.Key)] = true
+		}
+	}
+	return dirs, nil
+}
+
+// recordRun adds the run with its projects, artifacts, uploads & deletions to the catalog
+func recordRun(run *report.Run) error {
+	var records []catalog.Record
+
+	for _, p := range run.Projects {
+		status := report.StatusSuccess
+		if p.Failed() {
+			status = report.StatusFailure
+		}
+
+		records = append(records, catalog.Record{
+			Type: catalog.TypeProject, RunId: run.Id, Server: p.Server, Project: p.Path, Dir: p.Dir,
+			Status: status, Error: strings.Join(p.Errors(), "; "),
+		})
+
+		for _, a := range p.Artifacts {
+			records = append(records, catalog.Record{
+				Type: catalog.TypeArtifact, RunId: run.Id, Server: p.Server, Project: p.Path, Dir: p.Dir,
+				Path: a.Path, Size: a.Size, Sha256: a.Sha256,
+			})
+		}
+
+		for _, d := range p.Deleted {
+			records = append(records, catalog.Record{
+				Type: catalog.TypeDeletion, RunId: run.Id, Server: p.Server, Project: p.Path,
+				Path: d.Path, Location: d.Location,
+			})
+		}
+	}
+
+	for _, s := range run.Servers {
+		for _, u := range s.Uploaded {
+			records = append(records, catalog.Record{
+				Type: catalog.TypeUpload, RunId: run.Id, Server: s.Name, Path: u.Path, Size: u.Size,
+			})
+		}
+	}
+
+	records = append(records, catalog.Record{
+		Type: catalog.TypeRun, RunId: run.Id, Time: run.End, Start: &run.Start, Status: run.Status,
+	})
+
+	return backupCatalog().Append(records...)
+}
+
+var (
+	// catalogSince limits runs query to runs finished within this duration
+	catalogSince time.Duration
+	// catalogFormat is output format of queries
+	catalogFormat string
+)
+
+func catalogFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&catalogSince, "since", 7*24*time.Hour, "with runs & failed, only runs finished within this `duration`")
+	fs.StringVar(&catalogFormat, "format", "table", "output `format`: table or json")
+}
+
+// catalogCmd runs catalog queries: runs, failed, last <server>/<project> & missing
+func catalogCmd(args []string) error {
+	if catalogFormat != "table" && catalogFormat != "json" {
+		return errors.New("invalid --format " + catalogFormat + ", expected table or json")
+	}
+
+	if len(args) == 0 {
+		return errors.New("expected query: runs, failed, last <server>/<project> or missing")
+	}
+
+	switch args[0] {
+	case "runs", "failed":
+		runs, err := backupCatalog().Runs(time.Now().Add(-catalogSince), args[0] == "failed")
+		if err != nil {
+			return err
+		}
+		return printRuns(runs)
+
+	case "last":
+		if len(args) != 2 {
+			return errors.New("expected args: last <server>/<project>")
+		}
+		srv, project, found := strings.Cut(args[1], "/")
+		if !found || project == "" {
+			return errors.New("invalid project " + args[1] + ", expected <server>/<project>")
+		}
+
+		r, found, err := backupCatalog().LastSuccessful(srv, project)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errors.New("no successful backup of " + args[1] + " in catalog")
+		}
+		return printRecords([]catalog.Record{r}, "TIME\tSERVER\tPROJECT\tDIR", func(r catalog.Record) string {
+			return fmt.Sprintf("%s\t%s\t%s\t%s", r.Time.Local().Format(time.DateTime), r.Server, r.Project, r.Dir)
+		})
+
+	case "missing":
+		return printMissingFromS3()
+
+	default:
+		return errors.New("unknown query " + args[0] + ", expected runs, failed, last or missing")
+	}
+}
+
+// printRuns prints runs with their failed projects
+func printRuns(runs []catalog.Record) error {
+	// catalog is read once for all runs
+	projects, _ := backupCatalog().ProjectsByRun()
+
+	return printRecords(runs, "FINISHED\tRUN\tSTATUS\tTOOK\tFAILED PROJECTS", func(r catalog.Record) string {
+		var failed []string
+		for _, p := range projects[r.RunId] {
+			if p.Status != report.StatusSuccess {
+				failed = append(failed, p.Server+"/"+p.Project+": "+p.Error)
+			}
+		}
+
+		return fmt.Sprintf(
+			"%s\t%s\t%s\t%s\t%s",
+			r.Time.Local().Format(time.DateTime), r.RunId, r.Status,
+			runDuration(r), strings.Join(failed, "; "),
+		)
+	})
+}
+
+func runDuration(r catalog.Record) string {
+	if r.Start == nil {
+		return "-"
+	}
+	return r.Time.Sub(*r.Start).Round(time.Second).String()
+}
+
+// printMissingFromS3 prints artifacts of servers using s3 which were never uploaded
+func printMissingFromS3() error {
+	c := config.Config{}
+	c.Parse()
+
+	var servers []string
+	for _, sc := range c.Servers {
+		if sc.S3User != "" && sc.S3Bucket != "" {
+			servers = append(servers, sc.Id())
+		}
+	}
+
+	missing, err := backupCatalog().MissingFromS3(servers)
+	if err != nil {
+		return err
+	}
+
+	return printRecords(missing, "TAKEN\tSERVER\tPROJECT\tARTIFACT\tSIZE", func(r catalog.Record) string {
+		return fmt.Sprintf(
+			"%s\t%s\t%s\t%s\t%s",
+			r.Time.Local().Format(time.DateTime), r.Server, r.Project, r.Path, util.FormatBytes(r.Size),
+		)
+	})
+}
+
+// printRecords prints @records as table (a row by @row) or json, by --format
+func printRecords(records []catalog.Record, header string, row func(r catalog.Record) string) error {
+	if catalogFormat == "json" {
+		if records == nil {
+			records = []catalog.Record{}
+		}
+		b, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	_, _ = fmt.Fprintln(w, header)
+	for _, r := range records {
+		_, _ = fmt.Fprintln(w, row(r))
+	}
+	return nil
+}
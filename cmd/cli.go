@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/util"
+	"github.com/fatih/color"
+	"io"
+	"os"
+	"strings"
+)
+
+// globalOptions are accepted before or after the command name
+type globalOptions struct {
+	configDir string
+	backupDir string
+	verbose   bool
+	quiet     bool
+	color     string
+	logFormat string
+	logLevel  string
+}
+
+var opts = globalOptions{
+	configDir: util.ConfigDir,
+	backupDir: util.BackupDir,
+	color:     "auto",
+	logFormat: logger.FormatText,
+	logLevel:  logger.LevelInfo.String(),
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	// setup registers command specific flags
+	setup func(fs *flag.FlagSet)
+	// run executes the command with positional args
+	run func(args []string) error
+}
+
+// exit codes, so cron wrappers & monitoring can react
+const (
+	exitOk = 0
+	// exitFailure is for failed commands & backups in which every project failed
+	exitFailure = 1
+	exitUsage   = 2
+	// exitPartialFailure is for backups in which some of the projects or steps failed
+	exitPartialFailure = 3
+)
+
+// exitError is returned by commands to exit with a code other than exitFailure
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// defaultCommand runs when no command is given, so existing cron entries keep working
+const defaultCommand = "backup"
+
+func commands() []*command {
+	return []*command{
+		{
+			name:    "backup",
+			args:    "[<server>[/<project>] ...]",
+			summary: "Backup all servers & projects from config (default)",
+			setup:   backupFlags,
+			run:     backup,
+		},
+		{
+			name:    "gen",
+			summary: "Generate sample config",
+			run:     gen,
+		},
+		{
+			name:    "restore",
+			args:    "<server> <project-path> <yyyy-mm-dd>",
+			summary: "Push a project backup back onto its server",
+			setup:   restoreFlags,
+			run:     restore,
+		},
+		{
+			name:    "list",
+			summary: "List backups in local disk & S3",
+			setup:   listFlags,
+			run:     list,
+		},
+		{
+			name:    "verify",
+			args:    "[server] [project-path] [yyyy-mm-dd]",
+			summary: "Verify integrity of backups",
+			run:     verifyBackups,
+		},
+		{
+			name:    "daemon",
+			args:    "[<server>[/<project>] ...]",
+			summary: "Keep running & back up projects by their schedules",
+			setup:   daemonFlags,
+			run:     daemon,
+		},
+		{
+			name:    "config",
+			args:    "validate",
+			summary: "Validate servers & project configs strictly, report all problems",
+			run:     configCmd,
+		},
+		{
+			name:    "doctor",
+			args:    "[<server>[/<project>] ...]",
+			summary: "Diagnose setup of servers & projects step by step, with hints to fix problems",
+			setup:   doctorFlags,
+			run:     doctorCmd,
+		},
+		{
+			name:    "catalog",
+			args:    "runs | failed | last <server>/<project> | missing",
+			summary: "Query backup history: runs, failed runs, last good backup, artifacts missing from S3",
+			setup:   catalogFlags,
+			run:     catalogCmd,
+		},
+		{
+			name:    "notify",
+			args:    "test",
+			summary: "Send a test notification to configured webhooks & email",
+			run:     notifyCmd,
+		},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands() {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// runCli parses @args (without program name), runs the command & returns exit code
+func runCli(args []string) int {
+	global := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	global.SetOutput(io.Discard)
+	registerGlobalFlags(global)
+
+	err := global.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		printUsage(os.Stdout)
+		return exitOk
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	name, rest := defaultCommand, global.Args()
+	if len(rest) > 0 {
+		name, rest = rest[0], rest[1:]
+	}
+
+	// help [command]
+	if name == "help" {
+		if len(rest) > 0 && findCommand(rest[0]) != nil {
+			printCommandUsage(os.Stdout, findCommand(rest[0]))
+			return exitOk
+		}
+		printUsage(os.Stdout)
+		return exitOk
+	}
+
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintln(os.Stderr, "unknown command: "+name)
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	fs := newCommandFlagSet(cmd)
+	positional, err := parseInterspersed(fs, rest)
+	if errors.Is(err, flag.ErrHelp) {
+		printCommandUsage(os.Stdout, cmd)
+		return exitOk
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		printCommandUsage(os.Stderr, cmd)
+		return exitUsage
+	}
+
+	if err = applyGlobalOptions(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitUsage
+	}
+
+	if err = cmd.run(positional); err != nil {
+		fmt.Fprintln(os.Stderr, "❌ "+cmd.name+" failed. "+err.Error())
+
+		var ee *exitError
+		if errors.As(err, &ee) {
+			return ee.code
+		}
+		return exitFailure
+	}
+
+	return exitOk
+}
+
+// registerGlobalFlags registers global flags with current values as defaults,
+// so registering those in multiple flag sets doesn't reset already parsed values
+func registerGlobalFlags(fs *flag.FlagSet) {
+	fs.StringVar(&opts.configDir, "config", opts.configDir, "config `dir` containing servers.yml & project configs")
+	fs.StringVar(&opts.backupDir, "backup-dir", opts.backupDir, "default local backup `dir`")
+	fs.BoolVar(&opts.verbose, "v", opts.verbose, "verbose, print project logs in stdout too")
+	fs.BoolVar(&opts.verbose, "verbose", opts.verbose, "same as -v")
+	fs.BoolVar(&opts.quiet, "q", opts.quiet, "quiet, do not print run log in stdout")
+	fs.BoolVar(&opts.quiet, "quiet", opts.quiet, "same as -q")
+	fs.StringVar(&opts.color, "color", opts.color, "colored output: auto, always or never")
+	fs.StringVar(&opts.logFormat, "log-format", opts.logFormat, "format of log files: text or json (json lines), terminal output stays text")
+	fs.StringVar(&opts.logLevel, "log-level", opts.logLevel, "lowest level logged: debug, info, warn or error. debug includes every file zipped")
+}
+
+func newCommandFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	// global flags are accepted after the command too
+	registerGlobalFlags(fs)
+
+	if cmd.setup != nil {
+		cmd.setup(fs)
+	}
+	return fs
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(v string) error {
+	*sl = append(*sl, v)
+	return nil
+}
+
+// parseInterspersed parses flags which can be mixed with positional args & returns positional args.
+// Flags end at "--", args after it are positional even when those start with "-"
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	args, rest := splitAtTerminator(fs, args)
+	var positional []string
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return append(positional, rest...), nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// splitAtTerminator splits @args at the first "--" ending flags of @fs, a "--" given as flag value
+// (like --config --) doesn't end those. Rest is nil when there's no "--"
+func splitAtTerminator(fs *flag.FlagSet, args []string) (flags, rest []string) {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			return args[:i], args[i+1:]
+		}
+		if len(a) < 2 || a[0] != '-' || strings.Contains(a, "=") {
+			continue
+		}
+
+		// next arg is value of a non-boolean flag
+		f := fs.Lookup(strings.TrimLeft(a, "-"))
+		if f == nil {
+			continue
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+			i++
+		}
+	}
+	return args, nil
+}
+
+func applyGlobalOptions() error {
+	util.SetConfigDir(opts.configDir)
+	util.SetBackupDir(opts.backupDir)
+
+	switch opts.color {
+	case "auto":
+	case "always":
+		color.NoColor = false
+	case "never":
+		color.NoColor = true
+	default:
+		return errors.New("invalid --color value " + opts.color + ", expected auto, always or never")
+	}
+
+	if err := logger.SetFormat(opts.logFormat); err != nil {
+		return errors.New("invalid --log-format value " + opts.logFormat + ", expected text or json")
+	}
+
+	level, err := logger.ParseLevel(opts.logLevel)
+	if err != nil {
+		return errors.New("invalid --log-level value " + opts.logLevel + ", expected debug, info, warn or error")
+	}
+	logger.SetLevel(level)
+
+	return nil
+}
+
+func printUsage(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Usage: %s [global flags] [command] [command flags] [args]\n\n", os.Args[0])
+	_, _ = fmt.Fprintln(w, "Commands:")
+	for _, c := range commands() {
+		_, _ = fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	_, _ = fmt.Fprintln(w, "  help       Show help of a command")
+
+	_, _ = fmt.Fprintln(w, "\nGlobal flags:")
+	printFlags(w, registerGlobalFlags)
+
+	_, _ = fmt.Fprintf(w, "\nRun '%s help [command]' for command details.\n", os.Args[0])
+}
+
+func printCommandUsage(w io.Writer, cmd *command) {
+	_, _ = fmt.Fprintf(w, "Usage: %s %s [flags] %s\n\n%s\n", os.Args[0], cmd.name, cmd.args, cmd.summary)
+
+	if cmd.setup != nil {
+		_, _ = fmt.Fprintln(w, "\nFlags:")
+		printFlags(w, cmd.setup)
+	}
+
+	_, _ = fmt.Fprintln(w, "\nGlobal flags:")
+	printFlags(w, registerGlobalFlags)
+}
+
+// printFlags prints defaults of flags registered by @register
+func printFlags(w io.Writer, register func(fs *flag.FlagSet)) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(w)
+	register(fs)
+	fs.PrintDefaults()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestParseInterspersed(t *testing.T) {
+	tests := []struct {
+		name             string
+		args, positional []string
+		tags             []string
+		verbose          bool
+	}{
+		{"mixed", []string{"web-1", "-v", "--tag", "prod", "db-1"}, []string{"web-1", "db-1"}, []string{"prod"}, true},
+		{"terminator", []string{"-v", "--", "-web", "--tag"}, []string{"-web", "--tag"}, nil, true},
+		{"after positional", []string{"web-1", "--", "-v"}, []string{"web-1", "-v"}, nil, false},
+		{"value", []string{"--tag", "--", "web-1", "-v"}, []string{"web-1"}, []string{"--"}, true},
+		{"inline value", []string{"--tag=x", "--", "-v"}, []string{"-v"}, []string{"x"}, false},
+		{"only terminator", []string{"--"}, nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			var tags stringList
+			fs.Var(&tags, "tag", "")
+			verbose := fs.Bool("v", false, "")
+
+			positional, err := parseInterspersed(fs, tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(positional, tt.positional) {
+				t.Errorf("positional %q, want %q", positional, tt.positional)
+			}
+			if !reflect.DeepEqual([]string(tags), tt.tags) || *verbose != tt.verbose {
+				t.Errorf("tags %q, -v %v, want %q, %v", tags, *verbose, tt.tags, tt.verbose)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/util"
+)
+
+// configCmd runs config sub commands, only "validate" for now
+func configCmd(args []string) error {
+	if len(args) != 1 || args[0] != "validate" {
+		return errors.New("expected sub command: validate")
+	}
+
+	return validateConfig()
+}
+
+// validateConfig reports all problems of servers & project configs, errors when any of those is an error
+func validateConfig() error {
+	problems := config.Validate()
+
+	errCount := 0
+	for _, p := range problems {
+		if p.Severity == config.SeverityError {
+			errCount++
+			fmt.Println(util.ProjectFailLogf("%s", p.String()))
+		} else {
+			fmt.Println(p.String())
+		}
+	}
+
+	warnCount := len(problems) - errCount
+	if errCount > 0 {
+		return fmt.Errorf("config is invalid, %d errors, %d warnings", errCount, warnCount)
+	}
+
+	fmt.Println(util.ServerLogf("✅ Config is valid, %d warnings", warnCount))
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/report"
+	"github.com/apudiu/server-backup/internal/scheduler"
+	"github.com/apudiu/server-backup/internal/util"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// daemonTags selects projects to schedule by server or project tags
+var daemonTags stringList
+
+func daemonFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&noPreflight, "no-preflight", false, "skip checking tools, disk space, DB & s3 access before backing up")
+	fs.Var(&daemonTags, "tag", "schedule only projects having this server or project `tag`, can be repeated")
+}
+
+// daemon keeps running & backs up projects by their schedules till interrupted.
+// args are selectors like <server>[/<project>], same as backup
+func daemon(args []string) error {
+	c := config.Config{}
+	c.Parse()
+
+	if err := selectFromArgs(&c, args, daemonTags); err != nil {
+		return err
+	}
+
+	sch := scheduler.New(util.BackupDir+util.DS+"scheduler-state.json", daemonLogf)
+
+	jobCount := 0
+	for si := range c.Servers {
+		sc := &c.Servers[si]
+
+		for pi := range sc.Projects {
+			n, err := addProjectJobs(sch, sc, &sc.Projects[pi], c.Notifications)
+			if err != nil {
+				return err
+			}
+			jobCount += n
+		}
+	}
+
+	if jobCount == 0 {
+		return errors.New("no schedule found in config, specify schedule in server or project config")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	daemonLogf("🚀 Starting daemon with %d jobs", jobCount)
+	err := sch.Run(ctx)
+	daemonLogf("Daemon stopped")
+
+	return err
+}
+
+// addProjectJobs adds files & db backup jobs of a project by its schedule & returns number of added jobs
+func addProjectJobs(
+	sch *scheduler.Scheduler,
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	notifications config.Notifications,
+) (int, error) {
+	schedule := pc.ScheduleFor(sc)
+	group := sc.Id() + "/" + pc.Path
+
+	jitter, err := schedule.JitterDuration()
+	if err != nil {
+		return 0, fmt.Errorf("invalid schedule jitter %q of %s. %s", schedule.Jitter, group, err.Error())
+	}
+
+	kinds := []struct {
+		name  string
+		expr  string
+		steps backupSteps
+	}{
+		{"files", schedule.Files, backupSteps{files: true}},
+		{"db", schedule.Db, backupSteps{db: true}},
+	}
+
+	added := 0
+	for _, k := range kinds {
+		if k.expr == "" {
+			continue
+		}
+
+		cronSchedule, parseErr := scheduler.ParseSchedule(k.expr)
+		if parseErr != nil {
+			return 0, fmt.Errorf("invalid %s schedule %q of %s. %s", k.name, k.expr, group, parseErr.Error())
+		}
+		// artifacts are named by date, a copy is replaced only by a verified one though
+		if scheduler.RunsMoreThanDaily(cronSchedule, time.Now()) {
+			daemonLogf("⚠️ %s schedule %q of %s runs more than once a day, each run replaces the backup of the day",
+				k.name, k.expr, group)
+		}
+
+		steps := k.steps
+		sch.Add(scheduler.Job{
+			Key:      group + "/" + k.name,
+			Group:    group,
+			Schedule: cronSchedule,
+			Jitter:   jitter,
+			CatchUp:  schedule.CatchUpEnabled(),
+			Run: func() {
+				runScheduledBackup(sc, pc, steps, notifications)
+			},
+		})
+		added++
+	}
+
+	return added, nil
+}
+
+// runScheduledBackup backs up a project over its own connection, uploads the project dir
+// & notifies about the run as configured
+func runScheduledBackup(
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	steps backupSteps,
+	notifications config.Notifications,
+) {
+	l := logger.New().WithServer(sc.Id()).WithProject(pc.Path)
+	l.ToggleStdOut(!opts.quiet)
+	if err := l.SetFile(runLogFilePath()); err != nil {
+		fmt.Println("❌ Failed to open run.log", err.Error())
+	}
+	defer l.Close()
+
+	projOnSrvPathStr := fmt.Sprintf("%s:%s", sc.Id(), pc.SourcePath(sc))
+	l.AddHeader(util.ProjectLogf("Processing project: %s", projOnSrvPathStr))
+
+	run := report.New()
+
+	defer func() {
+		run.Finish()
+		sendNotifications(notifications, run, l)
+
+		if err := run.WriteSummary(runSummaryPath()); err != nil {
+			l.Error(util.ServerFailLogf("Failed to write run summary. %s", err.Error()))
+		}
+		if err := recordRun(run); err != nil {
+			l.Error(util.ServerFailLogf("Failed to record run in catalog. %s", err.Error()))
+		}
+	}()
+
+	rs := run.NewServer(sc.Id())
+	rp := run.NewProject(sc.Id(), pc.Path, pc.DestPath(sc))
+
+	connStep := rs.Begin(report.StepConnect)
+	conn, err := connectWithRetry(sc, l.WithStep(report.StepConnect), connStep)
+	if connStep.Done(0, err) != nil {
+		l.WithStep(report.StepConnect).Error(
+			util.ServerLogLn("Connection establishment with server", sc.Id(), "failed.", err.Error()),
+		)
+		_ = rp.Begin(report.StepConnect).Done(0, err)
+		return
+	}
+	defer conn.Close()
+
+	var s3Err error
+	if !noPreflight {
+		s3Err = preflightS3(sc, l.WithStep(report.StepPreflight), rs)
+	}
+
+	err = processProject(conn, sc, pc, steps, rp, readCatalogBackups(), l)
+	if err != nil {
+		l.Error(util.ProjectFailLogLn("Processing project failed", projOnSrvPathStr, err.Error()))
+	} else {
+		l.AddHeader(util.ProjectLogf("Processed project: " + projOnSrvPathStr))
+	}
+
+	if s3Err != nil {
+		rs.Begin(report.StepUpload).Skip("preflight failed")
+		return
+	}
+	_ = uploadBackups(sc, []string{filepath.Dir(pc.DestPath(sc))}, l.WithStep(report.StepUpload), rs)
+}
+
+// daemonLogf logs scheduler messages in stdout & run.log
+func daemonLogf(format string, a ...any) {
+	l := logger.New()
+	l.ToggleStdOut(!opts.quiet)
+	if err := l.SetFile(runLogFilePath()); err != nil {
+		fmt.Println("❌ Failed to open run.log", err.Error())
+	}
+	defer l.Close()
+
+	l.AddHeader(util.ServerLogf(format, a...))
+}
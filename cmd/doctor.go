@@ -0,0 +1,445 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/remotebackup"
+	"github.com/apudiu/server-backup/internal/server"
+	"github.com/apudiu/server-backup/internal/tasks"
+	"github.com/apudiu/server-backup/internal/util"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// doctor reports checks of a server setup step by step, counting failures
+type doctor struct {
+	failed int
+}
+
+// ok, warn, fail & skip print outcome of @check with @detail, failures & warnings print @hint on how to fix those
+func (d *doctor) ok(check, detail string) {
+	fmt.Printf("  ✅ %-14s %s\n", check, detail)
+}
+
+func (d *doctor) warn(check, detail, hint string) {
+	fmt.Printf("  ⚠️  %-14s %s\n", check, oneLine(detail))
+	d.hint(hint)
+}
+
+func (d *doctor) fail(check string, err error, hint string) {
+	d.failed++
+	fmt.Println(util.ProjectFailLogf("  ❌ %-14s %s", check, oneLine(err.Error())))
+	d.hint(hint)
+}
+
+func (d *doctor) skip(check, reason string) {
+	fmt.Printf("  ⏭️  %-14s %s\n", check, reason)
+}
+
+func (d *doctor) hint(hint string) {
+	if hint != "" {
+		fmt.Printf("     %-14s ↳ %s\n", "", hint)
+	}
+}
+
+// oneLine joins lines of joined errors
+func oneLine(s string) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), "\n", "; ")
+}
+
+// doctorWriteProbe enables checking s3 write access by uploading & deleting a probe object in the bucket
+var doctorWriteProbe bool
+
+func doctorFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&doctorWriteProbe, "write-probe", false, "check s3 write access by uploading & deleting an empty probe object in the bucket")
+}
+
+// doctorCmd diagnoses setup of servers & projects selected by args, like backup selectors.
+// Errors when any check failed
+func doctorCmd(args []string) error {
+	c := config.Config{}
+	c.Parse()
+
+	if err := selectFromArgs(&c, args, nil); err != nil {
+		return err
+	}
+
+	d := &doctor{}
+	for si := range c.Servers {
+		d.server(&c.Servers[si])
+	}
+
+	if d.failed > 0 {
+		return fmt.Errorf("%d checks failed", d.failed)
+	}
+	fmt.Println(util.ServerLogf("✅ All checks passed"))
+	return nil
+}
+
+// server checks the server & its projects
+func (d *doctor) server(sc *config.ServerConfig) {
+	fmt.Println(util.ServerLogf("Server %s (%s)", sc.Id(), sc.Address()))
+
+	for _, j := range sc.JumpHosts {
+		d.keys("jump auth", j.Address()+": ", j.Login(sc.SshLogin))
+	}
+	d.keys("auth", "", sc.SshLogin)
+
+	conn := d.connect(sc)
+	if conn != nil {
+		defer conn.Close()
+		d.system(conn, sc)
+	}
+
+	for pi := range sc.Projects {
+		d.project(conn, sc, &sc.Projects[pi])
+	}
+
+	d.s3(sc)
+	fmt.Println()
+}
+
+// keys checks credentials of auth methods of @login can be used, details are prefixed by @prefix
+func (d *doctor) keys(check, prefix string, login config.SshLogin) {
+	withPrefix := func(err error) error {
+		return errors.New(prefix + err.Error())
+	}
+
+	methods := login.Auth()
+	if len(methods) == 0 {
+		d.fail(check, withPrefix(errors.New("no auth method")), "Set privateKeyPath or password, or run ssh-agent with the key added")
+		return
+	}
+
+	for _, m := range methods {
+		switch m {
+		case config.AuthPublicKey:
+			signer, err := server.KeySigner(login)
+			if err != nil {
+				d.fail(check, withPrefix(err), "Check privateKeyPath is readable, set password when the key is encrypted")
+				continue
+			}
+			pub := signer.PublicKey()
+			detail := fmt.Sprintf("publickey %s %s from %s", pub.Type(), ssh.FingerprintSHA256(pub), login.Key)
+			if cert, ok := pub.(*ssh.Certificate); ok {
+				detail = fmt.Sprintf("publickey %s certificate of %s from %s",
+					cert.Key.Type(), ssh.FingerprintSHA256(cert.Key), login.CertificatePath())
+			}
+			d.ok(check, prefix+detail)
+
+		case config.AuthAgent:
+			sock := os.Getenv("SSH_AUTH_SOCK")
+			ac, err := net.Dial("unix", sock)
+			if err != nil {
+				d.fail(check, withPrefix(util.ErrWithPrefix("agent at "+sock+" is not reachable", err)), "Start ssh-agent & add the key by ssh-add")
+				continue
+			}
+			keys, err := agent.NewClient(ac).List()
+			_ = ac.Close()
+			switch {
+			case err != nil:
+				d.fail(check, withPrefix(util.ErrWithPrefix("Failed to list agent keys", err)), "Restart ssh-agent")
+			case len(keys) == 0:
+				d.warn(check, prefix+"agent has no keys", "Add the key by ssh-add")
+			default:
+				d.ok(check, fmt.Sprintf("%sagent with %d keys", prefix, len(keys)))
+			}
+
+		case config.AuthPassword:
+			if login.Password == "" {
+				d.fail(check, withPrefix(errors.New("password is not specified")), "Set password or remove password from authMethods")
+				continue
+			}
+			d.ok(check, prefix+"password is set")
+
+		default:
+			d.fail(check, withPrefix(errors.New("unknown auth method "+m)), "Use publickey, agent or password in authMethods")
+		}
+	}
+}
+
+// connect connects to the server once & reports reachability, host key & auth, returns nil on failure.
+// When a jump host fails, those are reported for the jump host, the server isn't reached then
+func (d *doctor) connect(sc *config.ServerConfig) *ssh.Client {
+	conn, err := server.ConnectToServer(sc)
+	if err == nil {
+		d.ok("connect", "reached "+sc.Address())
+		d.ok("host key", hostKeyDetail(sc.HostKey))
+		d.ok("ssh login", "logged in as "+sc.User)
+		return conn
+	}
+
+	host, login, prefix := sc.Address(), sc.SshLogin, ""
+	var jumpErr *server.JumpHostError
+	if errors.As(err, &jumpErr) {
+		host = "jump host " + jumpErr.Address
+		prefix = host + ": "
+		for _, j := range sc.JumpHosts {
+			if j.Address() == jumpErr.Address {
+				login = j.Login(sc.SshLogin)
+			}
+		}
+	}
+
+	switch {
+	case errors.Is(err, server.ErrNoAuthMethod):
+		d.skip("connect", "not tried, no usable auth method for "+host)
+		d.skip("host key", "not connected")
+		d.fail("ssh login", err, "Set privateKeyPath or password, or run ssh-agent with the key added")
+	case errors.Is(err, server.ErrHostKeyUnknown) || errors.Is(err, server.ErrHostKeyChanged):
+		d.ok("connect", "reached "+host)
+		d.fail("host key", err,
+			"Check the fingerprint with the server admin, then add it by ssh-keyscan to "+login.HostKey.KnownHostsPath()+
+				", pin it by hostKey.fingerprint or use hostKey.mode tofu")
+		d.skip("ssh login", "host key not verified")
+	case errors.Is(err, server.ErrAuthRejected):
+		// host key is verified before auth
+		d.ok("connect", "reached "+host)
+		d.ok("host key", prefix+hostKeyDetail(login.HostKey))
+		d.fail("ssh login", err,
+			"Check user & credentials, public key must be in ~/.ssh/authorized_keys of "+login.User+" in "+host)
+	default:
+		d.fail("connect", err,
+			"Check ip/host & port, firewall & jumpHosts. Raise connection.dialTimeout for slow links")
+		d.skip("host key", "not connected")
+		d.skip("ssh login", "not connected")
+	}
+	return nil
+}
+
+// hostKeyDetail tells how host key was verified
+func hostKeyDetail(hk config.HostKey) string {
+	switch {
+	case hk.Fingerprint != "":
+		return "matches pinned " + hk.Fingerprint
+	case hk.TrustOnFirstUse():
+		return "known or recorded (tofu) in " + hk.KnownHostsPath()
+	default:
+		return "known in " + hk.KnownHostsPath()
+	}
+}
+
+// system checks shell, OS, tools & project root of the server
+func (d *doctor) system(conn *ssh.Client, sc *config.ServerConfig) {
+	shell, osName, err := tasks.SystemInfo(conn)
+	if err != nil {
+		d.fail("shell & os", err, "Commands are run by the login shell of "+sc.User+", it must be a POSIX shell")
+	} else {
+		d.ok("shell & os", shell+", "+osName)
+	}
+
+	needDb := false
+	for _, pc := range sc.Projects {
+		needDb = needDb || pc.EnvFileInfo.Path != "" || pc.DbInfoAvailable()
+	}
+
+	d.tools(conn, filesTools, "Install zip in the server")
+	if needDb {
+		d.tools(conn, dbTools, "Install mysql client (mysqldump) & gzip in the server")
+	} else {
+		d.skip("tools", "mysqldump & gzip not needed, no project has DB")
+	}
+
+	sha, err := tasks.ToolVersion(conn, "sha256sum", "--version")
+	if err != nil {
+		sha, err = tasks.ToolVersion(conn, "shasum", "--version")
+	}
+	if err != nil {
+		d.warn("tools", "neither sha256sum nor shasum is available, copies can't be verified by checksum",
+			"Install coreutils (sha256sum) or perl (shasum) in the server")
+	} else {
+		d.ok("tools", "sha256 "+sha)
+	}
+
+	if sc.Streamed() {
+		d.skip("project root", "temp files not needed in stream transfer mode")
+		return
+	}
+	if err = preflightTempDir(conn, sc); err != nil {
+		d.fail("project root", err, "Check projectRoot exists & is writable by "+sc.User)
+		return
+	}
+	free, err := tasks.FreeSpace(conn, sc.ProjectRoot)
+	if err != nil {
+		d.fail("project root", err, "")
+		return
+	}
+	d.ok("project root", fmt.Sprintf("%s writable, %s free", sc.ProjectRoot, util.FormatBytes(free)))
+}
+
+// tools checks @tools are installed in the server
+func (d *doctor) tools(conn *ssh.Client, tools []tool, hint string) {
+	for _, t := range tools {
+		v, err := tasks.ToolVersion(conn, t.name, t.versionArg)
+		if err != nil {
+			d.fail("tools", err, hint)
+			continue
+		}
+		if v == "" {
+			v = "version unknown"
+		}
+		d.ok("tools", t.name+" "+v)
+	}
+}
+
+// project checks dir, env file & DB of the project, remote checks are skipped when @conn is nil
+func (d *doctor) project(conn *ssh.Client, sc *config.ServerConfig, pc *config.ProjectConfig) {
+	fmt.Println(util.ProjectLogf("  Project %s", pc.Path))
+
+	if conn == nil {
+		d.skip("project", "not connected")
+		return
+	}
+
+	size, err := tasks.DiskUsage(conn, pc.SourcePath(sc))
+	if err != nil {
+		d.fail("project dir", err, "Check projectRoot & path of the project")
+	} else {
+		d.ok("project dir", fmt.Sprintf("%s, %s", pc.SourcePath(sc), util.FormatBytes(size)))
+	}
+
+	if !d.envFile(conn, sc, pc) {
+		return
+	}
+
+	if !pc.DbInfoAvailable() {
+		if pc.EnvFileInfo.Path == "" {
+			d.skip("db info", "no DB configured")
+			return
+		}
+		d.fail("db info", errors.New("DB info is incomplete"), "Set values of all DB keys in the env file")
+		return
+	}
+	d.ok("db info", fmt.Sprintf("%s@%s:%d/%s", pc.DbInfo.User, pc.DbInfo.Host, pc.DbInfo.Port, pc.DbInfo.Name))
+
+	if err = tasks.DbCheckMySql(conn, pc); err != nil {
+		d.fail("db login", err,
+			"Check DB user, password & host. DB is reached from the server, so host is as seen by the server")
+		return
+	}
+	d.ok("db login", "can dump "+pc.DbInfo.Name)
+}
+
+// envFile reads env file of the project & reports which DB keys were found in it, DB info is filled from it.
+// Returns false when DB checks can't go on
+func (d *doctor) envFile(conn *ssh.Client, sc *config.ServerConfig, pc *config.ProjectConfig) bool {
+	ef := pc.EnvFileInfo
+	if ef.Path == "" {
+		d.skip("env file", "not specified, DB info is taken from project config")
+		return true
+	}
+
+	remoteEnvPath := pc.SourcePath(sc) + util.DS + ef.Path
+	content, err := tasks.GetFileContent(conn, remoteEnvPath)
+	if err != nil {
+		d.fail("env file", err, "envFileInfo.path is relative to project dir, it must be readable by "+sc.User)
+		return false
+	}
+
+	entries, ok := util.ParseEnvFromContent(content, '\n')
+	if !ok {
+		d.fail("env file", errors.New("failed to parse "+remoteEnvPath), "Env file must have KEY=value lines")
+		return false
+	}
+
+	keys := []struct{ setting, name string }{
+		{"dbHostKeyName", ef.DbHostKeyName},
+		{"dbPortKeyName", ef.DbPortKeyName},
+		{"dbUserKeyName", ef.DbUserKeyName},
+		{"dbPassKeyName", ef.DbPassKeyName},
+		{"dbNameKeyName", ef.DbNameKeyName},
+	}
+	var found, missing []string
+	for _, k := range keys {
+		switch {
+		case k.name == "":
+			missing = append(missing, "("+k.setting+" not set)")
+		case entries[k.name] == "":
+			missing = append(missing, k.name)
+		default:
+			found = append(found, k.name)
+		}
+	}
+
+	detail := remoteEnvPath + ", found " + strings.Join(found, ", ")
+	if len(found) == 0 {
+		detail = remoteEnvPath + ", no DB keys found"
+	}
+	if len(missing) > 0 {
+		d.fail("env file", errors.New(detail+", missing "+strings.Join(missing, ", ")),
+			"Set envFileInfo.db*KeyName to key names used in the env file")
+		return false
+	}
+
+	// invalid port would end the process while parsing
+	if _, err = strconv.Atoi(entries[ef.DbPortKeyName]); err != nil {
+		d.fail("env file", errors.New(ef.DbPortKeyName+" is not a port number"), "Fix DB port in the env file")
+		return false
+	}
+	if err = pc.ParseDbInfo(content, '\n'); err != nil {
+		d.fail("env file", err, "")
+		return false
+	}
+	d.ok("env file", detail)
+	return true
+}
+
+// s3 checks credentials of s3 user profile & permissions of it in the bucket
+func (d *doctor) s3(sc *config.ServerConfig) {
+	fmt.Println(util.ProjectLogf("  S3"))
+
+	if sc.S3User == "" || sc.S3Bucket == "" {
+		d.skip("s3", "s3User or s3Bucket not set, backups are kept locally only")
+		return
+	}
+
+	uldl, err := remotebackup.New(sc.S3User, sc.S3Bucket, sc.DestPath(), 10, logger.New())
+	if err != nil {
+		d.fail("s3 profile", err, "Configure profile "+sc.S3User+" in ~/.aws/config, like by aws configure --profile "+sc.S3User)
+		return
+	}
+
+	source, keyId, region, err := uldl.Credentials()
+	if err != nil {
+		d.fail("s3 profile", err, "Add credentials of profile "+sc.S3User+" in ~/.aws/credentials")
+		return
+	}
+	if region == "" {
+		d.warn("s3 profile", "no region for profile "+sc.S3User, "Set region of the profile in ~/.aws/config")
+	}
+	d.ok("s3 profile", fmt.Sprintf("%s: key %s from %s, region %s", sc.S3User, keyId, source, region))
+
+	exists, err := uldl.BucketExists()
+	switch {
+	case err != nil:
+		d.fail("s3 bucket", err, "Check bucket name & region, the user needs s3:ListBucket on it")
+		return
+	case !exists:
+		d.fail("s3 bucket", errors.New("bucket "+sc.S3Bucket+" doesn't exist"), "Create the bucket or fix s3Bucket")
+		return
+	}
+	d.ok("s3 bucket", sc.S3Bucket+" exists")
+
+	if err = uldl.CanList(); err != nil {
+		d.fail("s3 list", err, "Grant s3:ListBucket on the bucket")
+	} else {
+		d.ok("s3 list", "objects can be listed")
+	}
+
+	if !doctorWriteProbe {
+		d.skip("s3 write", "not checked, add --write-probe to upload & delete a probe object in the bucket")
+		return
+	}
+	if err = uldl.CanWrite(); err != nil {
+		d.fail("s3 write", err, "Grant s3:PutObject & s3:DeleteObject on objects of the bucket")
+	} else {
+		d.ok("s3 write", "objects can be uploaded & deleted")
+	}
+}
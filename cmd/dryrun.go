@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/remotebackup"
+	"github.com/apudiu/server-backup/internal/server"
+	"github.com/apudiu/server-backup/internal/tasks"
+	"github.com/apudiu/server-backup/internal/util"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+// planPrinter prints backup plan lines with indentation
+type planPrinter struct {
+	indent int
+}
+
+func (p *planPrinter) line(format string, a ...any) {
+	fmt.Println(strings.Repeat("  ", p.indent) + fmt.Sprintf(format, a...))
+}
+
+func (p *planPrinter) nested(fn func()) {
+	p.indent++
+	fn()
+	p.indent--
+}
+
+// dryRun prints what backup would do for every server & project without executing
+// or deleting anything. Servers are connected (unless @connect is false) only to read env files
+func dryRun(c *config.Config, connect bool) error {
+	p := &planPrinter{}
+	p.line(util.ServerLogf("🔍 Backup plan (dry run), nothing will be executed"))
+
+	cb := readCatalogBackups()
+	for si := range c.Servers {
+		planServer(p, &c.Servers[si], connect, cb)
+	}
+
+	return nil
+}
+
+func planServer(p *planPrinter, s *config.ServerConfig, connect bool, cb *catalogBackups) {
+	via := ""
+	for _, j := range s.JumpHosts {
+		via += " via " + j.Login(s.SshLogin).User + "@" + j.Address()
+	}
+	p.line(util.ServerLogf("Server: %s (%s@%s%s)", s.Id(), s.User, s.Address(), via))
+
+	p.nested(func() {
+		var conn *ssh.Client
+		if connect {
+			var err error
+			conn, err = server.ConnectToServer(s)
+			if err != nil {
+				p.line(util.ServerFailLogf("Connection failed, backup of this server would fail. %s", err.Error()))
+			} else {
+				p.line("Connection: ok")
+				defer conn.Close()
+			}
+		} else {
+			p.line("Connection: skipped")
+		}
+
+		p.line("Local backup dir: %s", s.DestPath())
+
+		for pi := range s.Projects {
+			planProject(p, conn, s, &s.Projects[pi], cb)
+		}
+
+		planUpload(p, s)
+	})
+}
+
+func planProject(
+	p *planPrinter,
+	conn *ssh.Client,
+	s *config.ServerConfig,
+	pc *config.ProjectConfig,
+	cb *catalogBackups,
+) {
+	p.line(util.ProjectLogf("Project: %s", pc.Path))
+
+	p.nested(func() {
+		p.line("Source: %s:%s", s.Id(), pc.SourcePath(s))
+		p.line("Local dir: %s", pc.DestPath(s))
+		p.line("Log file: %s", pc.LogFilePath(s))
+
+		// files
+		remoteZipPath, localZipPath := pc.ZipFilePath(s)
+		p.line("Files:")
+		p.nested(func() {
+			if s.Streamed() {
+				p.line("run: %s", tasks.ZipDirectoryCmd(pc.SourcePath(s), tasks.Stdout, pc.ExcludePaths))
+				p.line("stream --> %s", localZipPath)
+				return
+			}
+			p.line("run: %s", tasks.ZipDirectoryCmd(pc.SourcePath(s), remoteZipPath, pc.ExcludePaths))
+			p.line("copy: %s --> %s", remoteZipPath, localZipPath)
+			p.line("run: %s", tasks.DeletePathCmd(remoteZipPath))
+		})
+
+		// db
+		p.line("DB:")
+		p.nested(func() {
+			planDb(p, conn, s, pc)
+		})
+
+		if pc.VerifyBackup {
+			p.line("Verify: zip & DB dump integrity")
+		}
+
+		// retention
+		s3Enabled := s.S3User != "" && s.S3Bucket != ""
+		known, good := catalogRetention(cb, s, pc, s3Enabled, logger.New())
+		deletionList := pc.PlannedDeletionList(s, known, good)
+		p.line("Retention: keep %d good copies", pc.BackupCopiesCount())
+		p.nested(func() {
+			if len(deletionList) == 0 {
+				p.line("nothing to delete")
+				return
+			}
+			for _, d := range deletionList {
+				if exist, _ := util.IsPathExist(d); exist {
+					p.line("delete from local: %s", d)
+				}
+				if s3Enabled {
+					p.line("delete from bucket %s: %s", s.S3Bucket, d)
+				}
+			}
+		})
+	})
+}
+
+func planDb(p *planPrinter, conn *ssh.Client, s *config.ServerConfig, pc *config.ProjectConfig) {
+	if pc.EnvFileInfo.Path != "" {
+		remoteEnvPath := s.ProjectRoot + util.DS + pc.Path + util.DS + pc.EnvFileInfo.Path
+		p.line("env file: %s", remoteEnvPath)
+
+		if conn == nil {
+			p.line("DB info will be read from env file at run time, DB commands unknown without connection")
+			return
+		}
+	}
+
+	// reads env file only, nothing is changed in the server
+	if !resolveDbInfo(conn, s, pc, logger.New()) {
+		p.line(util.ProjectFailLogf("DB info unavailable, DB backup would be skipped"))
+		return
+	}
+
+	remoteDbDumpPath, localDbDumpPath := pc.DbDumpFilePath(s)
+	if s.Streamed() {
+		p.line("run: %s", tasks.DbDumpMySqlCmd(s, pc, tasks.Stdout, true))
+		p.line("stream --> %s", localDbDumpPath)
+		return
+	}
+	p.line("run: %s", tasks.DbDumpMySqlCmd(s, pc, remoteDbDumpPath, true))
+	p.line("copy: %s --> %s", remoteDbDumpPath, localDbDumpPath)
+	p.line("run: %s", tasks.DeletePathCmd(remoteDbDumpPath))
+}
+
+func planUpload(p *planPrinter, s *config.ServerConfig) {
+	if s.S3User == "" || s.S3Bucket == "" {
+		p.line("S3 upload: skipped, AWS s3 config unavailable")
+		return
+	}
+
+	p.line("S3 upload: bucket %s, profile %s", s.S3Bucket, s.S3User)
+	p.nested(func() {
+		// only dirs of selected projects are uploaded, when subset of projects is selected
+		uploadDirs := existingPaths(s.SelectedDestPaths())
+		nothingUploaded := s.ProjectsFiltered() && len(uploadDirs) == 0
+
+		if exist, _ := util.IsPathExist(s.DestPath()); !exist || nothingUploaded {
+			p.line("upload: all files of this run")
+			return
+		}
+
+		rb, err := remotebackup.New(s.S3User, s.S3Bucket, s.DestPath(), 10, logger.New())
+		if err != nil {
+			p.line(util.ServerFailLogf("AWS s3 err, upload would fail. %s", err.Error()))
+			return
+		}
+
+		fileList, err := rb.ChangedOrNew(uploadDirs...)
+		if err != nil {
+			p.line(util.ServerFailLogf("s3 listing err, upload would fail. %s", err.Error()))
+			return
+		}
+
+		for _, f := range fileList {
+			p.line("upload: %s", f)
+		}
+		p.line("upload: all files of this run")
+	})
+}
+
+// existingPaths returns only those @paths which exist in local fs
+func existingPaths(paths []string) []string {
+	var existing []string
+	for _, p := range paths {
+		if exist, _ := util.IsPathExist(p); exist {
+			existing = append(existing, p)
+		}
+	}
+	return existing
+}
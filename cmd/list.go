@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/inventory"
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/remotebackup"
+	"github.com/apudiu/server-backup/internal/util"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"os"
+	"text/tabwriter"
+)
+
+const (
+	formatTable = "table"
+	formatJson  = "json"
+)
+
+// listFormat is output format of list command
+var listFormat string
+
+func listFlags(fs *flag.FlagSet) {
+	fs.StringVar(&listFormat, "format", formatTable, "output format: table or json")
+}
+
+// list prints backup inventory of all servers from local disk & s3
+func list(_ []string) error {
+	if listFormat != formatTable && listFormat != formatJson {
+		return errors.New("invalid format " + listFormat + ", expected table or json")
+	}
+
+	c := config.Config{}
+	c.Parse()
+
+	servers := make([]inventory.Server, 0, len(c.Servers))
+	for si := range c.Servers {
+		srv, _ := collectInventory(&c.Servers[si])
+		servers = append(servers, srv)
+	}
+
+	if listFormat == formatJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(servers)
+	}
+
+	printInventoryTable(servers)
+	return nil
+}
+
+// collectInventory lists backups of a server, bucket errors are kept in the result.
+// returned bucket client is nil when s3 is not configured or unavailable
+func collectInventory(sc *config.ServerConfig) (inventory.Server, *remotebackup.UlDl) {
+	var objects []types.Object
+	var rb *remotebackup.UlDl
+	var remoteErr error
+
+	if sc.S3User != "" && sc.S3Bucket != "" {
+		rb, remoteErr = remotebackup.New(sc.S3User, sc.S3Bucket, sc.DestPath(), 10, logger.New())
+		if remoteErr == nil {
+			objects, remoteErr = rb.ListObjectsWithPrefix(remotebackup.ObjectKey(sc.DestPath()) + util.DS)
+		}
+	}
+
+	srv := inventory.Collect(sc, objects)
+	if remoteErr != nil {
+		srv.RemoteErr = remoteErr.Error()
+		rb = nil
+	}
+	return srv, rb
+}
+
+func printInventoryTable(servers []inventory.Server) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	_, _ = fmt.Fprintln(w, "SERVER\tPROJECT\tDATE\tKIND\tARTIFACT\tLOCAL SIZE\tREMOTE SIZE\tLOCATION")
+
+	for _, s := range servers {
+		if s.RemoteErr != "" {
+			_, _ = fmt.Fprintf(w, "%s\t(s3 listing failed: %s)\t\t\t\t\t\t\n", s.Name, s.RemoteErr)
+		}
+
+		for _, p := range s.Projects {
+			for _, b := range p.Backups {
+				for _, a := range b.Artifacts {
+					_, _ = fmt.Fprintf(
+						w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+						s.Name, p.Path, b.Date, a.Kind, a.Name,
+						sizeOrDash(a.LocalSize, a.Location != inventory.LocationRemote),
+						sizeOrDash(a.RemoteSize, a.Location != inventory.LocationLocal),
+						a.Location,
+					)
+				}
+			}
+		}
+	}
+}
+
+func sizeOrDash(size int64, available bool) string {
+	if !available {
+		return "-"
+	}
+	return util.FormatBytes(size)
+}
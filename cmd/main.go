@@ -1,91 +1,211 @@
 package main
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/apudiu/server-backup/internal/catalog"
 	"github.com/apudiu/server-backup/internal/config"
 	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/manifest"
+	"github.com/apudiu/server-backup/internal/notify"
 	"github.com/apudiu/server-backup/internal/remotebackup"
+	"github.com/apudiu/server-backup/internal/report"
 	"github.com/apudiu/server-backup/internal/server"
 	"github.com/apudiu/server-backup/internal/tasks"
 	"github.com/apudiu/server-backup/internal/util"
 	"golang.org/x/crypto/ssh"
+	"io"
 	"log"
 	"os"
-	"strings"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 func main() {
-	// get arg
-	arg, ok := util.GetCliArg(0)
+	os.Exit(runCli(os.Args[1:]))
+}
 
-	// generate sample config
-	if ok && arg == util.ConfigGenArg {
-		config.GenerateEmptyConfigFile()
-		return
+// gen generates sample config
+func gen(_ []string) error {
+	config.GenerateEmptyConfigFile()
+	return nil
+}
+
+var (
+	// backupDryRun prints backup plan instead of executing it
+	backupDryRun bool
+	// backupNoConnect skips connecting servers in dry run
+	backupNoConnect bool
+	// backupTags selects projects by server or project tags
+	backupTags stringList
+)
+
+func backupFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&backupDryRun, "dry-run", false, "print the backup plan without executing or deleting anything")
+	fs.BoolVar(&backupNoConnect, "no-connect", false, "with --dry-run, do not connect servers (DB commands can't be resolved from env files)")
+	fs.BoolVar(&noPreflight, "no-preflight", false, "skip checking tools, disk space, DB & s3 access before backing up")
+	fs.Var(&backupTags, "tag", "select projects having this server or project `tag`, can be repeated")
+}
+
+// backup does backup of servers & projects from config.
+// args are selectors like <server>[/<project>], all servers & projects are selected when none given
+func backup(args []string) error {
+	c := config.Config{}
+	c.Parse()
+
+	if err := selectFromArgs(&c, args, backupTags); err != nil {
+		return err
 	}
 
-	// or do backup from config
+	if backupDryRun {
+		return dryRun(&c, !backupNoConnect)
+	}
 
 	runLog := logger.New()
-	runLog.ToggleStdOut(true)
+	runLog.ToggleStdOut(!opts.quiet)
+	if err := runLog.SetFile(runLogFilePath()); err != nil {
+		return util.ErrWithPrefix("Failed to open run.log", err)
+	}
+	defer runLog.Close()
+
 	runLog.AddHeader(util.ServerLogf("🚀 Starting backup"))
 
-	c := config.Config{}
-	c.Parse()
-	//fmt.Printf("%+v \n", c)
+	run := report.New()
+	// catalog is read once for retention of all projects
+	cb := readCatalogBackups()
 
 	wg := sync.WaitGroup{}
 	wg.Add(len(c.Servers))
 
 	for si := range c.Servers {
 		go func(s *config.ServerConfig) {
-			runLog.AddHeader(util.ServerLogf("Processing server: " + s.Ip.String()))
-			processServer(s, runLog)
-			runLog.AddHeader(util.ServerLogf("Processed server: " + s.Ip.String()))
+			sl := runLog.WithServer(s.Id())
+			sl.AddHeader(util.ServerLogf("Processing server: " + s.Id()))
+			processServer(s, sl, run, cb)
+			sl.AddHeader(util.ServerLogf("Processed server: " + s.Id()))
 			wg.Done()
 		}(&c.Servers[si])
 	}
 
 	wg.Wait()
-	runLog.AddHeader("✅ Backup completed")
+	run.Finish()
+
+	switch run.Status {
+	case report.StatusSuccess:
+		runLog.AddHeader("✅ Backup completed")
+	case report.StatusPartial:
+		runLog.Error(util.ServerFailLogf("❌ Backup completed with failures"), logger.Fields{"failedProjects": run.FailedProjects()})
+	default:
+		runLog.Error(util.ServerFailLogf("❌ Backup failed"))
+	}
 
-	runLogFilePath := util.BackupDir + util.DS + "run.log"
+	sendNotifications(c.Notifications, run, runLog)
 
-	err := runLog.WriteToFile(runLogFilePath)
-	if err != nil {
-		log.Println("❌ Failed to write to run.log", err.Error())
-		os.Exit(1)
+	if err := run.WriteSummary(runSummaryPath()); err != nil {
+		runLog.Error(util.ServerFailLogf("Failed to write run summary. %s", err.Error()))
+	}
+	if err := recordRun(run); err != nil {
+		runLog.Error(util.ServerFailLogf("Failed to record run in catalog. %s", err.Error()))
 	}
 
+	return runResultErr(run)
 }
 
-func processServer(s *config.ServerConfig, runLogger *logger.Logger) {
+// runLogFilePath is path of the log of all runs
+func runLogFilePath() string {
+	return util.BackupDir + util.DS + "run.log"
+}
 
-	conn, connErr := server.ConnectToServer(s)
-	if connErr != nil {
-		runLogger.AddHeader(
-			util.ServerLogLn("Connection establishment with server", s.Ip.String(), "failed.", connErr.Error()),
+// runSummaryPath is path of JSON summary of the last run, next to run.log
+func runSummaryPath() string {
+	return util.BackupDir + util.DS + "run-summary.json"
+}
+
+// runResultErr returns error with exit code matching the run status, nil on success
+func runResultErr(run *report.Run) error {
+	switch run.Status {
+	case report.StatusSuccess:
+		return nil
+	case report.StatusPartial:
+		return &exitError{
+			code: exitPartialFailure,
+			err:  fmt.Errorf("%d of %d projects failed, see %s", run.FailedProjects(), len(run.Projects), runSummaryPath()),
+		}
+	default:
+		return &exitError{
+			code: exitFailure,
+			err:  fmt.Errorf("all projects failed, see %s", runSummaryPath()),
+		}
+	}
+}
+
+// selectFromArgs narrows down config to projects selected by @args selectors & @tags
+func selectFromArgs(c *config.Config, args []string, tags []string) error {
+	selectors := make([]config.Selector, 0, len(args))
+	for _, a := range args {
+		s, err := config.ParseSelector(a)
+		if err != nil {
+			return err
+		}
+		selectors = append(selectors, s)
+	}
+
+	return c.Select(selectors, tags)
+}
+
+// sendNotifications notifies about the run as configured & logs failures in @runLogger
+func sendNotifications(cfg config.Notifications, run *report.Run, runLogger *logger.Logger) {
+	err := notify.Notify(cfg, run, util.BackupDir+util.DS+"notify-state.json")
+	if err != nil {
+		runLogger.Error(util.ServerFailLogf("Notification failed. %s", err.Error()))
+	}
+}
+
+func processServer(s *config.ServerConfig, runLogger *logger.Logger, run *report.Run, cb *catalogBackups) {
+	rs := run.NewServer(s.Id())
+
+	connStep := rs.Begin(report.StepConnect)
+	conn, connErr := connectWithRetry(s, runLogger.WithStep(report.StepConnect), connStep)
+	if connStep.Done(0, connErr) != nil {
+		runLogger.WithStep(report.StepConnect).Error(
+			util.ServerLogLn("Connection establishment with server", s.Id(), "failed.", connErr.Error()),
 		)
+		// none of the projects can be backed up
+		for pi := range s.Projects {
+			pc := &s.Projects[pi]
+			_ = run.NewProject(s.Id(), pc.Path, pc.DestPath(s)).Begin(report.StepConnect).Done(0, connErr)
+		}
 		return
 	}
 	defer conn.Close()
 
+	// s3 is checked once for all projects, backups are still taken locally when it fails
+	var s3Err error
+	if !noPreflight {
+		s3Err = preflightS3(s, runLogger.WithStep(report.StepPreflight), rs)
+	}
+
 	wg := sync.WaitGroup{}
 	wg.Add(len(s.Projects))
 
 	for pi := range s.Projects {
 		go func(p *config.ProjectConfig) {
-			projOnSrvPathStr := fmt.Sprintf("%s:%s", s.Ip.String(), p.SourcePath(s))
-			runLogger.AddHeader(util.ProjectLogf("Processing project: %s", projOnSrvPathStr))
+			projOnSrvPathStr := fmt.Sprintf("%s:%s", s.Id(), p.SourcePath(s))
+			pl := runLogger.WithProject(p.Path)
+			pl.AddHeader(util.ProjectLogf("Processing project: %s", projOnSrvPathStr))
 
-			er := processProject(conn, s, p)
+			er := processProject(conn, s, p, allBackupSteps, run.NewProject(s.Id(), p.Path, p.DestPath(s)), cb, pl)
 			if er != nil {
-				runLogger.AddHeader(
+				pl.Error(
 					util.ProjectFailLogLn("Processing project failed", projOnSrvPathStr, er.Error()),
 				)
 			} else {
-				runLogger.AddHeader(util.ProjectLogf("Processed project: " + projOnSrvPathStr))
+				pl.AddHeader(util.ProjectLogf("Processed project: " + projOnSrvPathStr))
 			}
 
 			wg.Done()
@@ -95,186 +215,625 @@ func processServer(s *config.ServerConfig, runLogger *logger.Logger) {
 	wg.Wait()
 
 	// upload to s3
-	uploadBackups(s, runLogger)
+	if s3Err != nil {
+		rs.Begin(report.StepUpload).Skip("preflight failed")
+		return
+	}
+	_ = uploadBackups(s, s.SelectedDestPaths(), runLogger.WithStep(report.StepUpload), rs)
+}
+
+// backupSteps selects parts of a project backup to run
+type backupSteps struct {
+	files bool
+	db    bool
 }
 
+var allBackupSteps = backupSteps{files: true, db: true}
+
+// processProject backs up the project by @steps, records outcome of each step in @rp &
+// returns errors of failed steps. Old backups are removed by backup dirs known to catalog @cb.
+// Steps are logged in the project log, retries & reconnects in @runLogger too
 func processProject(
 	conn *ssh.Client,
 	sc *config.ServerConfig,
 	pc *config.ProjectConfig,
+	steps backupSteps,
+	rp *report.Project,
+	cb *catalogBackups,
+	runLogger *logger.Logger,
 ) error {
 	// logger
-	l := logger.New()
+	l := logger.New().WithServer(sc.Id()).WithProject(pc.Path)
+	l.ToggleStdOut(opts.verbose)
+	defer l.Close()
+
+	start := time.Now()
 
 	// prepare paths
 	localPath := pc.DestPath(sc)
-	err := util.CreatePath(localPath, 0755, false)
+	prepareStep := rp.Begin(report.StepPrepare)
+	err := prepareStep.Done(0, util.CreatePath(localPath, 0755, false))
 	if err != nil {
-		l.AddHeader("Failed to create local path. " + err.Error())
-		logErr := l.WriteToFile(pc.LogFilePath(sc))
-		if logErr != nil {
-			log.Println("Failed to write in log file", logErr.Error())
-		}
+		l.WithStep(report.StepPrepare).Error("Failed to create local path. " + err.Error())
 		return err
 	}
 
+	// logs are written to the project log file as those arrive
+	logErr := l.SetFile(pc.LogFilePath(sc))
+	if logErr != nil {
+		log.Println(logErr, "Failed to open log file")
+		logErr = util.ErrWithPrefix("Failed to open log file", logErr)
+	}
+
+	// parts failing preflight checks are not started
+	var pf preflight
+	if !noPreflight {
+		pf = preflightProject(conn, sc, pc, steps, l.WithStep(report.StepPreflight), rp)
+	}
+
 	wg := sync.WaitGroup{}
-	wg.Add(2)
+	var filesErr, dbErr error
+	sums := &checksums{}
+
+	if steps.files && pf.filesErr != nil {
+		filesErr = skipByPreflight(rp, pf.filesErr, report.StepZip, report.StepCopy)
+	}
+	if steps.db && pf.dbErr != nil {
+		dbErr = skipByPreflight(rp, pf.dbErr, report.StepDbDump, report.StepDbCopy)
+	}
 
 	// zip the dir
-	go func() {
-		zipAndCopyFiles(conn, sc, pc, l)
-		wg.Done()
-	}()
+	if steps.files && pf.filesErr == nil {
+		wg.Add(1)
+		go func() {
+			filesErr = zipAndCopyFiles(conn, sc, pc, l, runLogger, rp, sums)
+			wg.Done()
+		}()
+	}
 
 	// do db backup
-	go func() {
-		dumpDdAndCopy(conn, sc, pc, l)
-		wg.Done()
-	}()
+	if steps.db && pf.dbErr == nil {
+		wg.Add(1)
+		go func() {
+			dbErr = dumpDdAndCopy(conn, sc, pc, pf.dbResolved, l, runLogger, rp, sums)
+			wg.Done()
+		}()
+	}
 
 	wg.Wait()
 
-	// keen n backups of this project & delete rest
-	removeExtraProjectBackups(sc, pc, l)
+	// describe copied artifacts, a skipped db dump (project without DB) has no file
+	_, localDbDumpPath := pc.DbDumpFilePath(sc)
+	dumpExist, _ := util.IsPathExist(localDbDumpPath)
+	filesCopied := steps.files && filesErr == nil
+	dbCopied := steps.db && dbErr == nil && dumpExist
+
+	var manifestErr error
+	if filesCopied || dbCopied {
+		manifestStep := rp.Begin(report.StepManifest)
+		ml := l.WithStep(report.StepManifest)
+		manifestErr = manifestStep.Done(0, updateManifest(sc, pc, start, filesCopied, dbCopied, sums, rp, ml))
+		if manifestErr != nil {
+			ml.Error(manifestErr.Error())
+		}
+	}
 
-	// write all logs to file
-	err = l.WriteToFile(pc.LogFilePath(sc))
-	if err != nil {
-		log.Println(err, "Failed to write in log file")
-		return err
+	// check integrity of taken backup
+	var verifyErr error
+	if pc.VerifyBackup {
+		verifyStep := rp.Begin(report.StepVerify)
+		verifyErr = verifyStep.Done(0, verifyProjectBackup(sc, pc, l.WithStep(report.StepVerify)))
 	}
 
-	return nil
+	// keen n backups of this project & delete rest
+	retentionErr := removeExtraProjectBackups(sc, pc, cb, l.WithStep(report.StepRetention), rp)
+
+	return errors.Join(filesErr, dbErr, manifestErr, verifyErr, retentionErr, logErr)
 }
 
 func zipAndCopyFiles(
 	conn *ssh.Client,
 	s *config.ServerConfig,
 	p *config.ProjectConfig,
-	l *logger.Logger,
-) {
+	l, runLogger *logger.Logger,
+	rp *report.Project,
+	sums *checksums,
+) error {
+	if s.Streamed() {
+		return zipAndStreamFiles(conn, s, p, l, rp, sums)
+	}
+
 	remotePath := p.SourcePath(s)
 	remoteZipPath, localZipPath := p.ZipFilePath(s)
 
-	_, err := tasks.ZipDirectory(conn, remotePath, remoteZipPath, p.ExcludePaths, l)
-	if err != nil {
-		l.AddHeader(fmt.Sprintf("Ziping failed for %s", remotePath))
-		return
+	zl := l.WithStep(report.StepZip)
+	zipStep := rp.Begin(report.StepZip)
+	_, err := tasks.ZipDirectory(conn, remotePath, remoteZipPath, p.ExcludePaths, zl)
+	if zipStep.Done(0, err) != nil {
+		zl.Error(fmt.Sprintf("Ziping failed for %s", remotePath))
+		return util.ErrWithPrefix("Ziping failed", err)
 	}
 
 	// copy zip from server to local disk & log result
-	l.AddHeader(fmt.Sprintf("Copying: %s --> %s", remoteZipPath, localZipPath))
-
-	_, err = server.GetFileFromServer(conn, remoteZipPath, localZipPath)
-	if err != nil {
-		l.AddHeader(fmt.Sprintf("Copy err: %s --> %s. %s", remoteZipPath, localZipPath, err.Error()))
+	cl := l.WithStep(report.StepCopy)
+	cl.AddHeader(fmt.Sprintf("Copying: %s --> %s", remoteZipPath, localZipPath))
+
+	copyStep := rp.Begin(report.StepCopy)
+	conn, closeConn, copyErr := downloadWithRetry(conn, s, remoteZipPath, localZipPath, cl, runLogger.WithStep(report.StepCopy), copyStep, sums)
+	defer closeConn()
+	if copyStep.Done(util.PathSize(localZipPath), copyErr) != nil {
+		cl.Error(fmt.Sprintf("Copy err: %s --> %s. %s", remoteZipPath, localZipPath, copyErr.Error()))
+		copyErr = util.ErrWithPrefix("Zip copy failed", copyErr)
 	} else {
-		l.AddHeader(fmt.Sprintf("Copy Done: %s --> %s", remoteZipPath, localZipPath))
+		cl.Info(
+			fmt.Sprintf("Copy Done: %s --> %s", remoteZipPath, localZipPath),
+			logger.Fields{"bytes": copyStep.Bytes, "durationMs": copyStep.DurationMs},
+		)
 	}
 
-	// delete remote file
+	// delete remote file, failing to do so doesn't affect the backup
 	_, err = tasks.DeletePath(conn, remoteZipPath)
 	if err != nil {
-		l.AddHeader(fmt.Sprintf("Remote zip deletion err: %s", remoteZipPath))
+		cl.Warn(fmt.Sprintf("Remote zip deletion err: %s", remoteZipPath))
 	}
+
+	return copyErr
 }
 
-func dumpDdAndCopy(
+// zipAndStreamFiles streams zip of project files straight to the local zip file, copying is done by zip step
+func zipAndStreamFiles(
 	conn *ssh.Client,
 	s *config.ServerConfig,
 	p *config.ProjectConfig,
 	l *logger.Logger,
-) {
-	// try parsing env file if available
-	if p.EnvFileInfo.Path != "" {
-		remoteEnvPath := s.ProjectRoot + util.DS + p.Path + util.DS + p.EnvFileInfo.Path
-		envContent, err := tasks.GetFileContent(conn, remoteEnvPath)
-		if err != nil {
-			l.AddHeader("Error getting env file. " + err.Error())
-		} else {
-			err = p.ParseDbInfo(envContent, '\n')
-			if err != nil {
-				l.AddHeader("Error parsing env content. " + err.Error())
-			}
-		}
+	rp *report.Project,
+	sums *checksums,
+) error {
+	remotePath := p.SourcePath(s)
+	_, localZipPath := p.ZipFilePath(s)
+
+	zl := l.WithStep(report.StepZip)
+	zl.AddHeader(fmt.Sprintf("Streaming zip: %s --> %s", remotePath, localZipPath))
+
+	zipStep := rp.Begin(report.StepZip)
+	err := streamToFile(localZipPath, sums, func(w io.Writer) error {
+		_, e := tasks.ZipDirectoryStream(conn, remotePath, p.ExcludePaths, w, zl)
+		return e
+	})
+	if zipStep.Done(util.PathSize(localZipPath), err) != nil {
+		zl.Error(fmt.Sprintf("Zip streaming failed for %s. %s", remotePath, err.Error()))
+		return util.ErrWithPrefix("Zip streaming failed", err)
+	}
+
+	zl.Info(
+		fmt.Sprintf("Stream done: %s --> %s", remotePath, localZipPath),
+		logger.Fields{"bytes": zipStep.Bytes, "durationMs": zipStep.DurationMs},
+	)
+	rp.Begin(report.StepCopy).Skip("streamed by zip step")
+	return nil
+}
+
+// dumpDdAndCopy dumps project DB & copies the dump, DB info is read from the env file unless @dbResolved
+func dumpDdAndCopy(
+	conn *ssh.Client,
+	s *config.ServerConfig,
+	p *config.ProjectConfig,
+	dbResolved bool,
+	l, runLogger *logger.Logger,
+	rp *report.Project,
+	sums *checksums,
+) error {
+	dl := l.WithStep(report.StepDbDump)
+	dumpStep := rp.Begin(report.StepDbDump)
+
+	// preflight has already read the env file
+	dbAvailable := p.DbInfoAvailable()
+	if !dbResolved {
+		dbAvailable = resolveDbInfo(conn, s, p, dl)
 	}
 
 	// when db info unavailable, (failed to parse or explicitly not provided)
-	if p.DbInfo.Host == nil || p.DbInfo.Port == 0 || p.DbInfo.User == "" || p.DbInfo.Pass == "" || p.DbInfo.Name == "" {
-		l.AddHeader("DB info unavailable, skipping DB backup")
-		return
+	if !dbAvailable {
+		dl.Warn("DB info unavailable, skipping DB backup")
+
+		// project without DB is fine, but env file specified means DB was expected
+		if p.EnvFileInfo.Path != "" {
+			return dumpStep.Done(0, errors.New("DB info unavailable from env file "+p.EnvFileInfo.Path))
+		}
+		dumpStep.Skip("DB info unavailable")
+		return nil
+	}
+
+	if s.Streamed() {
+		return streamDbDump(conn, s, p, dl, rp, dumpStep, sums)
 	}
 
 	remoteDbDumpPath, localDbDumpPath := p.DbDumpFilePath(s)
 
-	_, err := tasks.DbDumpMySql(conn, s, p, l, remoteDbDumpPath)
-	if err != nil {
-		l.AddHeader("DB dumping error. " + err.Error())
-		return
+	_, err := tasks.DbDumpMySql(conn, s, p, dl, remoteDbDumpPath)
+	if dumpStep.Done(0, err) != nil {
+		dl.Error("DB dumping error. " + err.Error())
+		return util.ErrWithPrefix("DB dumping failed", err)
 	}
 
 	// download db dump
-	l.AddHeader("Copying " + remoteDbDumpPath + " to " + localDbDumpPath)
+	cl := l.WithStep(report.StepDbCopy)
+	cl.AddHeader("Copying " + remoteDbDumpPath + " to " + localDbDumpPath)
 
-	_, err = server.GetFileFromServer(conn, remoteDbDumpPath, localDbDumpPath)
-	if err != nil {
-		l.AddHeader(fmt.Sprintf("DB dump copy err: %s --> %s. %s", remoteDbDumpPath, localDbDumpPath, err.Error()))
+	copyStep := rp.Begin(report.StepDbCopy)
+	conn, closeConn, copyErr := downloadWithRetry(
+		conn, s, remoteDbDumpPath, localDbDumpPath, cl, runLogger.WithStep(report.StepDbCopy), copyStep, sums,
+	)
+	defer closeConn()
+	if copyStep.Done(util.PathSize(localDbDumpPath), copyErr) != nil {
+		cl.Error(fmt.Sprintf("DB dump copy err: %s --> %s. %s", remoteDbDumpPath, localDbDumpPath, copyErr.Error()))
+		copyErr = util.ErrWithPrefix("DB dump copy failed", copyErr)
 	} else {
-		l.AddHeader(fmt.Sprintf("Copy done: %s --> %s", remoteDbDumpPath, localDbDumpPath))
+		cl.Info(
+			fmt.Sprintf("Copy done: %s --> %s", remoteDbDumpPath, localDbDumpPath),
+			logger.Fields{"bytes": copyStep.Bytes, "durationMs": copyStep.DurationMs},
+		)
 	}
 
 	// delete remote file
 	_, err = tasks.DeletePath(conn, remoteDbDumpPath)
 	if err != nil {
-		l.AddHeader(fmt.Sprintf("Remote DB dump deletion err: %s", remoteDbDumpPath))
+		cl.Warn(fmt.Sprintf("Remote DB dump deletion err: %s", remoteDbDumpPath))
+	}
+
+	return copyErr
+}
+
+// streamDbDump streams DB dump straight to the local dump file, copying is done by @dumpStep
+func streamDbDump(
+	conn *ssh.Client,
+	s *config.ServerConfig,
+	p *config.ProjectConfig,
+	dl *logger.Logger,
+	rp *report.Project,
+	dumpStep *report.Step,
+	sums *checksums,
+) error {
+	_, localDbDumpPath := p.DbDumpFilePath(s)
+	dl.AddHeader("Streaming DB dump to " + localDbDumpPath)
+
+	err := streamToFile(localDbDumpPath, sums, func(w io.Writer) error {
+		_, e := tasks.DbDumpMySqlStream(conn, s, p, dl, w)
+		return e
+	})
+	if dumpStep.Done(util.PathSize(localDbDumpPath), err) != nil {
+		dl.Error("DB dump streaming error. " + err.Error())
+		return util.ErrWithPrefix("DB dump streaming failed", err)
+	}
+
+	dl.Info(
+		"Stream done: "+localDbDumpPath,
+		logger.Fields{"bytes": dumpStep.Bytes, "durationMs": dumpStep.DurationMs},
+	)
+	rp.Begin(report.StepDbCopy).Skip("streamed by db dump step")
+	return nil
+}
+
+// downloadWithRetry downloads @remotePath to @localPath, retrying as configured. Download is kept in <localPath>.part
+// till its checksum is verified, so a failed copy never replaces an earlier artifact. When @conn is lost, retries
+// use a new connection, so sftp downloads are resumed. Retries & reconnects are logged in @l & @runLogger
+// & recorded in @step. Returned connection is the one used last, for cleaning up, caller need to call @closeFn (defer)
+func downloadWithRetry(
+	conn *ssh.Client,
+	s *config.ServerConfig,
+	remotePath, localPath string,
+	l, runLogger *logger.Logger,
+	step *report.Step,
+	sums *checksums,
+) (c *ssh.Client, closeFn func(), err error) {
+	var own *ssh.Client
+	closeFn = func() {
+		if own != nil {
+			_ = own.Close()
+		}
+	}
+
+	c = conn
+	attempt := 0
+	err = s.Connection.Backoff().Retry(func() error {
+		attempt++
+		if attempt > 1 && !server.Alive(c) {
+			newConn, connErr := server.ConnectToServer(s)
+			if connErr != nil {
+				return connErr
+			}
+			closeFn()
+			own, c = newConn, newConn
+			step.Reconnects++
+			msg := "Reconnected to " + s.Id() + " for retrying the copy of " + remotePath
+			l.Info(msg)
+			runLogger.Info(msg)
+		}
+		// an earlier artifact of the day is replaced only by a complete & verified copy
+		part := localPath + util.PartSuffix
+		if dlErr := downloadFile(c, s, remotePath, part, l); dlErr != nil {
+			return dlErr
+		}
+		sum, verifyErr := verifyDownload(c, remotePath, part, l)
+		if verifyErr != nil {
+			return verifyErr
+		}
+		if renameErr := os.Rename(part, localPath); renameErr != nil {
+			return renameErr
+		}
+		if sum != "" {
+			sums.set(localPath, sum)
+		}
+		return nil
+	}, logRetry(l, step, "Copy of "+remotePath, runLogger))
+	return
+}
+
+// verifyDownload compares SHA-256 of downloaded @localPath with @remotePath & returns it, empty when it can't be
+// verified. A mismatching file is removed, so it's downloaded again from scratch by the retry
+func verifyDownload(c *ssh.Client, remotePath, localPath string, l *logger.Logger) (string, error) {
+	remoteSum, err := tasks.FileSha256(c, remotePath)
+	if errors.Is(err, tasks.ErrNoSha256Tool) {
+		l.Warn("Checksum not verified, " + err.Error())
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err = verifyLocalSha256(localPath, remoteSum); err != nil {
+		_ = os.Remove(localPath)
+		return "", err
+	}
+
+	l.Info("Checksum verified: "+localPath, logger.Fields{"sha256": remoteSum})
+	return remoteSum, nil
+}
+
+// downloadFile copies @remotePath of the server to @localPath by sftp or scp, as configured.
+// Progress of sftp downloads is logged in @l
+func downloadFile(conn *ssh.Client, s *config.ServerConfig, remotePath, localPath string, l *logger.Logger) error {
+	if s.Transfer != config.TransferSftp {
+		_, err := server.GetFileFromServer(conn, remotePath, localPath)
+		return err
+	}
+
+	return server.DownloadSftp(conn, remotePath, localPath, func(p server.Progress) {
+		msg := fmt.Sprintf(
+			"Downloading %s: %s of %s, %s/s", remotePath,
+			util.FormatBytes(p.Done), util.FormatBytes(p.Total), util.FormatBytes(int64(p.Rate)),
+		)
+		if p.Resumed > 0 {
+			msg += ", resumed at " + util.FormatBytes(p.Resumed)
+		}
+		if eta := p.Eta.Round(time.Second); eta > 0 {
+			msg += ", ETA " + eta.String()
+		}
+		l.Info(msg, logger.Fields{"bytes": p.Done, "total": p.Total, "rate": int64(p.Rate), "etaMs": p.Eta.Milliseconds()})
+	})
+}
+
+// streamToFile runs @stream writing to a temp file next to @path, which replaces @path when the stream
+// succeeds, so a broken stream never leaves a partial artifact. Stream is hashed as it arrives & the written
+// file is verified against it, verified hash is recorded in @sums
+func streamToFile(path string, sums *checksums, stream func(w io.Writer) error) error {
+	part := path + util.PartSuffix
+	f, err := os.Create(part)
+	if err != nil {
+		return util.ErrWithPrefix("Failed to create "+part, err)
+	}
+
+	h := sha256.New()
+	bw := bufio.NewWriterSize(f, 1<<20)
+	err = stream(io.MultiWriter(bw, h))
+	if err == nil {
+		err = bw.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	streamSum := hex.EncodeToString(h.Sum(nil))
+	if err == nil {
+		err = verifyLocalSha256(part, streamSum)
+	}
+
+	if err != nil {
+		_ = os.Remove(part)
+		return err
+	}
+	if err = os.Rename(part, path); err != nil {
+		return err
+	}
+
+	sums.set(path, streamSum)
+	return nil
+}
+
+// verifyLocalSha256 checks file at @path has SHA-256 @want
+func verifyLocalSha256(path, want string) error {
+	sum, _, err := manifest.FileSha256(path)
+	if err != nil {
+		return util.ErrWithPrefix("Failed to hash "+path, err)
+	}
+	if sum != want {
+		return fmt.Errorf("sha256 mismatch of %s, local %s, source %s", path, sum, want)
 	}
+	return nil
+}
+
+// remoteArtifactPaths returns where zip & DB dump of the project are written in the server,
+// Stdout when those are streamed
+func remoteArtifactPaths(sc *config.ServerConfig, pc *config.ProjectConfig) (zipPath, dumpPath string) {
+	if sc.Streamed() {
+		return tasks.Stdout, tasks.Stdout
+	}
+	zipPath, _ = pc.ZipFilePath(sc)
+	dumpPath, _ = pc.DbDumpFilePath(sc)
+	return
+}
+
+// connectWithRetry connects to the server, retrying temporary failures as configured. Retries are
+// logged in @l & counted in @step
+func connectWithRetry(sc *config.ServerConfig, l *logger.Logger, step *report.Step) (conn *ssh.Client, err error) {
+	err = sc.Connection.Backoff().Retry(func() (e error) {
+		conn, e = server.ConnectToServer(sc)
+		if e != nil && !server.Retryable(e) {
+			return util.Permanent(e)
+		}
+		return
+	}, logRetry(l, step, "Connection to "+sc.Id()))
+	return
 }
 
-func uploadBackups(sc *config.ServerConfig, runLogger *logger.Logger) {
+// logRetry returns retry callback logging failed attempt of @what in @l & @more loggers (like the run logger)
+// & recording it in @step (optional)
+func logRetry(
+	l *logger.Logger,
+	step *report.Step,
+	what string,
+	more ...*logger.Logger,
+) func(attempt int, err error, wait time.Duration) {
+	return func(attempt int, err error, wait time.Duration) {
+		if step != nil {
+			step.Retries++
+			step.RetryErrors = append(step.RetryErrors, err.Error())
+		}
+		for _, rl := range append([]*logger.Logger{l}, more...) {
+			rl.Warn(
+				fmt.Sprintf("%s failed, retrying in %s. %s", what, wait, err.Error()),
+				logger.Fields{"attempt": attempt, "retryInMs": wait.Milliseconds()},
+			)
+		}
+	}
+}
+
+// resolveDbInfo tries to fill project DB info from the remote env file (if specified)
+// and reports whether DB info is usable
+func resolveDbInfo(
+	conn *ssh.Client,
+	s *config.ServerConfig,
+	p *config.ProjectConfig,
+	l *logger.Logger,
+) bool {
+	// try parsing env file if available
+	if p.EnvFileInfo.Path != "" {
+		remoteEnvPath := s.ProjectRoot + util.DS + p.Path + util.DS + p.EnvFileInfo.Path
+		var envContent []byte
+		err := s.Connection.Backoff().Retry(func() (e error) {
+			envContent, e = tasks.GetFileContent(conn, remoteEnvPath)
+			return
+		}, logRetry(l, nil, "Env file read"))
+		if err != nil {
+			l.Error("Error getting env file. " + err.Error())
+		} else {
+			err = p.ParseDbInfo(envContent, '\n')
+			if err != nil {
+				l.Error("Error parsing env content. " + err.Error())
+			}
+		}
+	}
+
+	return p.DbInfoAvailable()
+}
+
+// uploadBackups uploads new or changed backups of the server, only from @dirs when given.
+// Outcome is recorded as upload step of @rs
+func uploadBackups(sc *config.ServerConfig, dirs []string, runLogger *logger.Logger, rs *report.Server) error {
+	uploadStep := rs.Begin(report.StepUpload)
+
 	if sc.S3User == "" || sc.S3Bucket == "" {
 		runLogger.AddHeader(
-			util.ServerLogLn("AWS s3 config unavailable in ", sc.Ip.String(), ". Skipping s3 upload!"),
+			util.ServerLogLn("AWS s3 config unavailable in ", sc.Id(), ". Skipping s3 upload!"),
 		)
-		return
+		uploadStep.Skip("AWS s3 config unavailable")
+		return nil
 	}
 
 	uldl, remoteErr := remotebackup.New(
 		sc.S3User, sc.S3Bucket, sc.DestPath(), 10, runLogger,
 	)
 	if remoteErr != nil {
-		runLogger.AddHeader(
-			util.ServerFailLogf("AWS s3 err for %s. %s", sc.Ip.String(), remoteErr.Error()),
+		runLogger.Error(
+			util.ServerFailLogf("AWS s3 err for %s. %s", sc.Id(), remoteErr.Error()),
 		)
-		return
+		return uploadStep.Done(0, util.ErrWithPrefix("AWS s3 err for "+sc.Id(), remoteErr))
+	}
+
+	uploaded, uploadedBytes, uldlErr := uldl.UploadChangedOrNew(dirs...)
+	for _, f := range uploaded {
+		rs.AddUploaded(report.File{Path: f, Size: util.PathSize(f)})
 	}
 
-	uldlErr := uldl.UploadChangedOrNew()
 	if uldlErr != nil {
-		runLogger.AddHeader(
-			util.ServerFailLogf("s3 upload err for %s. %s ", sc.Ip.String(), uldlErr.Error()),
+		runLogger.Error(
+			util.ServerFailLogf("s3 upload err for %s. %s ", sc.Id(), uldlErr.Error()),
+			logger.Fields{"files": len(uploaded), "bytes": uploadedBytes},
 		)
+		return uploadStep.Done(uploadedBytes, util.ErrWithPrefix("s3 upload err for "+sc.Id(), uldlErr))
 	}
+
+	runLogger.Info(
+		util.ServerLogf("Uploaded %d files to s3", len(uploaded)),
+		logger.Fields{"files": len(uploaded), "bytes": uploadedBytes},
+	)
+	return uploadStep.Done(uploadedBytes, nil)
 }
 
+// removeExtraProjectBackups deletes backups exceeding backup copies of the project from local disk & s3.
+// Backups are taken from local disk & catalog @cb (like ones deleted locally but still in s3), failed ones
+// don't count as copies. Outcome, freed local bytes & deleted dirs are recorded in @rp
 func removeExtraProjectBackups(
 	sc *config.ServerConfig,
 	pc *config.ProjectConfig,
+	cb *catalogBackups,
 	l *logger.Logger,
-) {
-	deletionList := pc.GetDeletionList(sc)
+	rp *report.Project,
+) error {
+	s3Enabled := sc.S3User != "" && sc.S3Bucket != ""
+	known, good := catalogRetention(cb, sc, pc, s3Enabled, l)
+
+	// today's backup is not in catalog yet
+	todayGood := !rp.Failed()
+	isGood := func(dir string) bool {
+		if dir == filepath.Clean(rp.Dir) {
+			return todayGood
+		}
+		return good(dir)
+	}
+
+	step := rp.Begin(report.StepRetention)
+
+	deletionList := pc.GetDeletionList(sc, known, isGood)
 	if deletionList == nil {
-		return
+		return step.Done(0, nil)
 	}
 
+	var (
+		errs  []error
+		freed int64
+	)
+
 	//delete from local
 	for _, dDir := range deletionList {
+		if exist, _ := util.IsPathExist(dDir); !exist {
+			continue
+		}
+
+		size := util.PathSize(dDir)
 		err := os.RemoveAll(dDir)
 		if err != nil {
-			l.AddHeader("Local backup deletion err. " + err.Error())
+			l.Error("Local backup deletion err. " + err.Error())
+			errs = append(errs, util.ErrWithPrefix("Local backup deletion failed", err))
 			continue
 		}
-		l.AddHeader("Deleted from local: " + dDir)
+		freed += size
+		rp.AddDeleted(report.Deletion{Path: dDir, Location: catalog.LocationLocal})
+		l.Info("Deleted from local: "+dDir, logger.Fields{"bytes": size})
+	}
+
+	if !s3Enabled {
+		return step.Done(freed, errors.Join(errs...))
 	}
 
 	// delete from remote
@@ -282,15 +841,20 @@ func removeExtraProjectBackups(
 		sc.S3User, sc.S3Bucket, sc.DestPath(), 10, l,
 	)
 	if err != nil {
-		l.AddHeader("Bucket err. " + err.Error())
-		return
+		l.Error("Bucket err. " + err.Error())
+		return step.Done(freed, errors.Join(append(errs, util.ErrWithPrefix("Bucket err", err))...))
 	}
 
-	err, _ = rb.DeleteObjects(deletionList)
-	if err != nil {
-		l.AddHeader("Delete from bucket err. " + err.Error())
-		return
+	for _, dDir := range deletionList {
+		n, delErr := rb.DeleteDir(dDir)
+		if delErr != nil {
+			l.Error("Delete from bucket err. " + delErr.Error())
+			errs = append(errs, util.ErrWithPrefix("Delete from bucket failed", delErr))
+			continue
+		}
+		rp.AddDeleted(report.Deletion{Path: dDir, Location: catalog.LocationS3})
+		l.Info(fmt.Sprintf("Deleted from bucket: %s, %d files", dDir, n), logger.Fields{"files": n})
 	}
 
-	l.AddHeader("Deleted from bucket: " + strings.Join(deletionList, ","))
+	return step.Done(freed, errors.Join(errs...))
 }
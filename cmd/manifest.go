@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/manifest"
+	"github.com/apudiu/server-backup/internal/report"
+	"github.com/apudiu/server-backup/internal/tasks"
+	"github.com/apudiu/server-backup/internal/util"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checksums holds SHA-256 of copied artifacts verified against the server, by local path.
+// Files & DB steps fill it concurrently
+type checksums struct {
+	mu   sync.Mutex
+	sums map[string]string
+}
+
+func (c *checksums) set(path, sum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sums == nil {
+		c.sums = map[string]string{}
+	}
+	c.sums[path] = sum
+}
+
+// get returns verified hash of @path, empty when it wasn't verified
+func (c *checksums) get(path string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sums[path]
+}
+
+// updateManifest records artifacts copied by this run (started at @start) in the manifest of today's backup
+// with their hashes verified while copying (@sums) & adds those to @rp. Manifest is created when missing,
+// artifacts of earlier runs of the day are kept
+func updateManifest(
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	start time.Time,
+	filesCopied, dbCopied bool,
+	sums *checksums,
+	rp *report.Project,
+	l *logger.Logger,
+) error {
+	dir := pc.DestPath(sc)
+
+	m, err := manifest.Load(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		m, err = &manifest.Manifest{}, nil
+	}
+	if err != nil {
+		// broken manifest is replaced, artifacts of earlier runs are lost from it
+		l.AddHeader("Replacing manifest. " + err.Error())
+		m = &manifest.Manifest{}
+	}
+
+	m.Server = sc.Id()
+	m.Project = pc.Path
+	m.Date = filepath.Base(dir)
+	m.ToolVersion = util.Version
+	m.Start = start
+	m.End = time.Now()
+	m.SourcePath = pc.SourcePath(sc)
+	m.ExcludePaths = pc.ExcludePaths
+
+	var errs []error
+	addArtifact := func(path, cmd string) {
+		if err := m.AddArtifact(path, cmd, sums.get(path)); err != nil {
+			errs = append(errs, err)
+			return
+		}
+
+		a, _ := m.Artifact(filepath.Base(path))
+		rp.AddArtifact(report.File{Path: path, Size: a.Size, Sha256: a.Sha256})
+	}
+
+	remoteZipPath, remoteDbDumpPath := remoteArtifactPaths(sc, pc)
+
+	if filesCopied {
+		_, localZipPath := pc.ZipFilePath(sc)
+		addArtifact(localZipPath, tasks.ZipDirectoryCmd(pc.SourcePath(sc), remoteZipPath, pc.ExcludePaths))
+	}
+
+	if dbCopied {
+		_, localDbDumpPath := pc.DbDumpFilePath(sc)
+		m.DbName = pc.DbInfo.Name
+		addArtifact(localDbDumpPath, tasks.DbDumpMySqlCmd(sc, pc, remoteDbDumpPath, true))
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		return util.ErrWithPrefix("Failed to hash artifacts", err)
+	}
+
+	if err = m.Write(dir); err != nil {
+		return util.ErrWithPrefix("Failed to write manifest", err)
+	}
+
+	l.AddHeader("Manifest written: " + manifest.Path(dir))
+	return nil
+}
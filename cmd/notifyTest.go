@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/notify"
+	"github.com/apudiu/server-backup/internal/report"
+	"github.com/apudiu/server-backup/internal/util"
+)
+
+// notifyCmd runs notify sub commands, only "test" for now
+func notifyCmd(args []string) error {
+	if len(args) != 1 || args[0] != "test" {
+		return errors.New("expected sub command: test")
+	}
+
+	return notifyTest()
+}
+
+// notifyTest sends a sample failed run summary to every configured channel, regardless of "on" setting
+func notifyTest() error {
+	c := config.Config{}
+	c.Parse()
+
+	if !c.Notifications.Enabled() {
+		return errors.New("no notification channel configured, add webhooks or smtp under notifications in servers.yml")
+	}
+
+	run := report.New()
+	_ = run.NewProject("0.0.0.0", "notification-test-ok", "").Begin(report.StepZip).Done(0, nil)
+	_ = run.NewProject("0.0.0.0", "notification-test-failed", "").Begin(report.StepZip).
+		Done(0, errors.New("sample failure, sent by notify test"))
+	run.Finish()
+
+	if err := notify.Send(c.Notifications, run); err != nil {
+		return err
+	}
+
+	fmt.Println(util.ServerLogf("✅ Test notification sent"))
+	return nil
+}
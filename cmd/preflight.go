@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/remotebackup"
+	"github.com/apudiu/server-backup/internal/report"
+	"github.com/apudiu/server-backup/internal/tasks"
+	"github.com/apudiu/server-backup/internal/util"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"path/filepath"
+)
+
+// noPreflight skips preflight checks of backup & daemon
+var noPreflight bool
+
+// tool is a remote command a backup part needs
+type tool struct {
+	name, versionArg string
+}
+
+var (
+	filesTools = []tool{{"zip", "-v"}}
+	dbTools    = []tool{{"mysqldump", "--version"}, {"gzip", "--version"}}
+)
+
+// preflightS3 checks s3 bucket of the server is accessible, nothing is checked when s3 isn't configured.
+// Failure is recorded as preflight step of @rs
+func preflightS3(sc *config.ServerConfig, l *logger.Logger, rs *report.Server) error {
+	if sc.S3User == "" || sc.S3Bucket == "" {
+		return nil
+	}
+
+	step := rs.Begin(report.StepPreflight)
+	err := step.Done(0, preflightBucket(sc, l))
+	if err != nil {
+		l.Error(util.ServerFailLogf("Preflight failed for %s, s3 upload will be skipped. %s", sc.Id(), err.Error()))
+	}
+	return err
+}
+
+// preflightBucket checks s3 bucket of the server exists & is accessible by the s3 user
+func preflightBucket(sc *config.ServerConfig, l *logger.Logger) error {
+	uldl, err := remotebackup.New(sc.S3User, sc.S3Bucket, sc.DestPath(), 10, l)
+	if err != nil {
+		return util.ErrWithPrefix("AWS s3 err", err)
+	}
+
+	exists, err := uldl.BucketExists()
+	if err != nil {
+		return util.ErrWithPrefix("s3 bucket "+sc.S3Bucket+" is not accessible by "+sc.S3User, err)
+	}
+	if !exists {
+		return errors.New("s3 bucket " + sc.S3Bucket + " doesn't exist")
+	}
+	return nil
+}
+
+// dbDumpReserve is local & remote space kept for the DB dump when the project has no earlier dump to estimate it by
+const dbDumpReserve int64 = 100 * 1024 * 1024
+
+// errNoSpace tells free space is less than needed
+var errNoSpace = errors.New("not enough space")
+
+// preflight is outcome of project preflight checks, errors tell why a backup part can't be taken
+type preflight struct {
+	filesErr, dbErr error
+	// dbResolved tells DB info is already resolved from the env file, so the DB part needn't read it again
+	dbResolved bool
+}
+
+// preflightProject checks the server & local disk can take backup parts of the project selected by @steps,
+// before any remote work is started. Outcome is recorded as preflight step of @rp
+func preflightProject(
+	conn *ssh.Client,
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	steps backupSteps,
+	l *logger.Logger,
+	rp *report.Project,
+) preflight {
+	step := rp.Begin(report.StepPreflight)
+	fields := logger.Fields{}
+
+	var pf preflight
+	// DB dump lands in the same disks as zip, so its size is counted in space needed by files
+	var dumpSize int64
+	if steps.db {
+		pf.dbErr = preflightDb(conn, sc, pc, l, fields)
+		pf.dbResolved = true
+
+		if pf.dbErr == nil && pc.DbInfoAvailable() {
+			dumpSize = dbDumpEstimate(sc, pc)
+			fields["dbDumpBytes"] = dumpSize
+		}
+	}
+	if steps.files {
+		pf.filesErr = preflightFiles(conn, sc, pc, dumpSize, l, fields)
+	} else if dumpSize > 0 {
+		pf.dbErr = preflightSpace(conn, sc, pc, dumpSize, "DB dump", fields)
+	}
+
+	if pf.filesErr != nil {
+		l.Error("Preflight failed, files won't be backed up. "+pf.filesErr.Error(), fields)
+	}
+	if pf.dbErr != nil {
+		l.Error("Preflight failed, DB won't be backed up. "+pf.dbErr.Error(), fields)
+	}
+	if step.Done(0, errors.Join(pf.filesErr, pf.dbErr)) == nil {
+		l.Info("Preflight passed", fields)
+	}
+	return pf
+}
+
+// preflightFiles checks required tools & disk space of zipping project files, besides @dumpSize bytes
+// needed by the DB dump. Findings are added to @fields. Size of the files counts excluded paths too, so lack of
+// space is only warned about when the project excludes paths
+func preflightFiles(
+	conn *ssh.Client,
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	dumpSize int64,
+	l *logger.Logger,
+	fields logger.Fields,
+) error {
+	if err := preflightTools(conn, filesTools, fields); err != nil {
+		return err
+	}
+
+	srcPath := pc.SourcePath(sc)
+	size, err := tasks.DiskUsage(conn, srcPath)
+	if err != nil {
+		return err
+	}
+	fields["sourceBytes"] = size
+
+	if !sc.Streamed() {
+		if err = preflightTempDir(conn, sc); err != nil {
+			return err
+		}
+	}
+
+	// zip is smaller than the files mostly, their size is the upper bound
+	what := "zip"
+	if dumpSize > 0 {
+		what = "zip & DB dump"
+	}
+	err = preflightSpace(conn, sc, pc, size+dumpSize, what, fields)
+	if errors.Is(err, errNoSpace) && len(pc.ExcludePaths) > 0 {
+		l.Warn("Space may not be enough, size of files includes excluded paths. "+err.Error(), fields)
+		return nil
+	}
+	return err
+}
+
+// preflightSpace checks @need bytes of @what fit in the server (when not streamed, those are kept there
+// till copied) & in the local backup dir. Findings are added to @fields
+func preflightSpace(
+	conn *ssh.Client,
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	need int64,
+	what string,
+	fields logger.Fields,
+) error {
+	if !sc.Streamed() {
+		free, err := tasks.FreeSpace(conn, sc.ProjectRoot)
+		if err != nil {
+			return err
+		}
+		fields["remoteFreeBytes"] = free
+
+		if free < need {
+			return fmt.Errorf(
+				"%w in the server for %s in %s, %s free, up to %s needed. Set transfer: stream to not keep them in the server",
+				errNoSpace, what, sc.ProjectRoot, util.FormatBytes(free), util.FormatBytes(need),
+			)
+		}
+	}
+
+	localPath := pc.DestPath(sc)
+	free, err := util.FreeSpace(localPath)
+	if err != nil {
+		return util.ErrWithPrefix("Failed to get free space of "+localPath, err)
+	}
+	fields["localFreeBytes"] = free
+
+	if free < need {
+		return fmt.Errorf(
+			"%w locally for %s in %s, %s free, up to %s needed",
+			errNoSpace, what, localPath, util.FormatBytes(free), util.FormatBytes(need),
+		)
+	}
+	return nil
+}
+
+// dbDumpEstimate returns expected size of the DB dump, that's size of the latest local dump of the project DB,
+// or dbDumpReserve when there's none
+func dbDumpEstimate(sc *config.ServerConfig, pc *config.ProjectConfig) int64 {
+	pattern := filepath.Dir(pc.DestPath(sc)) + util.DS + "*" + util.DS + "*_" + pc.DbInfo.Name + ".sql.gz"
+	matches, _ := filepath.Glob(pattern)
+
+	// names start with the date, so the last one is the latest
+	for i := len(matches) - 1; i >= 0; i-- {
+		if info, err := os.Stat(matches[i]); err == nil && info.Size() > 0 {
+			return info.Size()
+		}
+	}
+	return dbDumpReserve
+}
+
+// preflightDb checks DB info, required tools & DB login of dumping project DB, findings are added to @fields.
+// A project without DB passes
+func preflightDb(
+	conn *ssh.Client,
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	l *logger.Logger,
+	fields logger.Fields,
+) error {
+	if !resolveDbInfo(conn, sc, pc, l) {
+		if pc.EnvFileInfo.Path != "" {
+			return errors.New("DB info unavailable from env file " + pc.EnvFileInfo.Path)
+		}
+		return nil
+	}
+
+	if err := preflightTools(conn, dbTools, fields); err != nil {
+		return err
+	}
+	if !sc.Streamed() {
+		if err := preflightTempDir(conn, sc); err != nil {
+			return err
+		}
+	}
+	return tasks.DbCheckMySql(conn, pc)
+}
+
+// preflightTempDir checks zip & DB dump can be made in project root of the server, they're kept there till copied
+func preflightTempDir(conn *ssh.Client, sc *config.ServerConfig) error {
+	if err := tasks.CheckWritable(conn, sc.ProjectRoot); err != nil {
+		return fmt.Errorf("%w for temp files. Set transfer: stream to not keep them in the server", err)
+	}
+	return nil
+}
+
+// preflightTools checks @tools are installed in the server, their versions are added to @fields
+func preflightTools(conn *ssh.Client, tools []tool, fields logger.Fields) error {
+	for _, t := range tools {
+		v, err := tasks.ToolVersion(conn, t.name, t.versionArg)
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			v = "unknown"
+		}
+		fields[t.name] = v
+	}
+	return nil
+}
+
+// skipByPreflight records @stepNames of a backup part as skipped for failing preflight with @err, returns the reason
+func skipByPreflight(rp *report.Project, err error, stepNames ...string) error {
+	for _, name := range stepNames {
+		rp.Begin(name).Skip("preflight failed")
+	}
+	return util.ErrWithPrefix("Preflight failed", err)
+}
@@ -3,43 +3,18 @@ package main
 import (
 	"bytes"
 	"github.com/apudiu/server-backup/internal/config"
-	"github.com/apudiu/server-backup/internal/util"
-	"golang.org/x/crypto/ssh"
-	"net"
-	"strconv"
+	"github.com/apudiu/server-backup/internal/server"
 )
 
-// e.g. output, err := remoteRun("root", "MY_IP", "PRIVATE_KEY", "ls")
+// e.g. stdOut, stdErr, err := remoteRun(serverConfig, "ls")
 func remoteRun(c *config.ServerConfig, cmd string) (string, string, error) {
-	// privateKey could be read from a file, or retrieved from another storage
-	// source, such as the Secret Service / GNOME Keyring
-	key, err := ssh.ParsePrivateKey(util.ReadFromFile(c.Key))
-	if err != nil {
-		return "", "", err
-	}
-	// Authentication
-	cfg := &ssh.ClientConfig{
-		User: c.User,
-		// https://github.com/golang/go/issues/19767
-		// as clientConfig is non-permissive by default
-		// you can set ssh.InsercureIgnoreHostKey to allow any host
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(key),
-			ssh.Password(c.Password),
-		},
-		//alternatively, you could use a password
-		/*
-		   Auth: []ssh.AuthMethod{
-		       ssh.Password("PASSWORD"),
-		   },
-		*/
-	}
-	// Connect
-	client, err := ssh.Dial("tcp", net.JoinHostPort(c.Ip.String(), strconv.Itoa(c.Port)), cfg)
+	// Connect, host key is verified like for backups
+	client, err := server.ConnectToServer(c)
 	if err != nil {
 		return "", "", err
 	}
+	defer client.Close()
+
 	// Create a session. It is one session per command.
 	session, err := client.NewSession()
 	if err != nil {
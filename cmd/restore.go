@@ -0,0 +1,341 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/inventory"
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/manifest"
+	"github.com/apudiu/server-backup/internal/remotebackup"
+	"github.com/apudiu/server-backup/internal/server"
+	"github.com/apudiu/server-backup/internal/tasks"
+	"github.com/apudiu/server-backup/internal/util"
+	"golang.org/x/crypto/ssh"
+	"path/filepath"
+	"time"
+)
+
+// restoreTarget is remote dir to restore files into, project source dir when empty
+var restoreTarget string
+
+func restoreFlags(fs *flag.FlagSet) {
+	fs.StringVar(&restoreTarget, "target", "", "remote `dir` to restore files into, instead of project dir")
+}
+
+// restore pushes a project backup (files & DB) back onto its server.
+// args: server, project path & backup date
+func restore(args []string) error {
+	if len(args) != 3 {
+		return errors.New("expected args: <server> <project-path> <yyyy-mm-dd>")
+	}
+	name, projectPath, date := args[0], args[1], args[2]
+
+	if _, err := time.Parse(time.DateOnly, date); err != nil {
+		return util.ErrWithPrefix("Invalid backup date "+date, err)
+	}
+
+	c := config.Config{}
+	c.Parse()
+
+	sc, found := c.FindServer(name)
+	if !found {
+		return errors.New("server " + name + " not found in config")
+	}
+
+	pc, found := sc.FindProject(projectPath)
+	if !found {
+		return errors.New("project " + projectPath + " not found in config of " + name)
+	}
+
+	// restore into project source dir unless asked otherwise
+	targetDir := restoreTarget
+	if targetDir == "" {
+		targetDir = pc.SourcePath(sc)
+	}
+
+	l := logger.New().WithServer(sc.Id()).WithProject(projectPath)
+	l.ToggleStdOut(true)
+	if logErr := l.SetFile(util.BackupDir + util.DS + "restore.log"); logErr != nil {
+		l.Warn("Failed to open restore.log " + logErr.Error())
+	}
+	defer l.Close()
+
+	l.AddHeader(util.ProjectLogf("🚀 Restoring %s:%s from %s", sc.Id(), projectPath, date))
+
+	err := restoreProject(sc, pc, date, targetDir, l)
+	if err != nil {
+		l.Error(util.ProjectFailLogLn("Restore failed", err.Error()))
+	} else {
+		l.AddHeader(util.ProjectLogf("✅ Restore completed"))
+	}
+
+	return err
+}
+
+func restoreProject(
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	date, targetDir string,
+	l *logger.Logger,
+) error {
+	zipPath, dumpPath, err := findBackupArtifacts(sc, pc, date, l)
+	if err != nil {
+		return err
+	}
+	if zipPath == "" && dumpPath == "" {
+		return fmt.Errorf("no backup found for %s on %s", pc.Path, date)
+	}
+
+	conn, err := connectWithRetry(sc, l, nil)
+	if err != nil {
+		return util.ErrWithPrefix("Connection establishment with server "+sc.Id()+" failed", err)
+	}
+	defer conn.Close()
+
+	if zipPath != "" {
+		err = restoreFiles(conn, sc, pc, zipPath, targetDir, l)
+		if err != nil {
+			return err
+		}
+	} else {
+		l.AddHeader("Zip unavailable, skipping files restore")
+	}
+
+	if dumpPath == "" {
+		l.AddHeader("DB dump unavailable, skipping DB restore")
+		return nil
+	}
+
+	// when db info unavailable, (failed to parse or explicitly not provided)
+	if !resolveDbInfo(conn, sc, pc, l) {
+		return errors.New("DB info unavailable, can not restore DB")
+	}
+
+	return restoreDb(conn, sc, pc, dumpPath, l)
+}
+
+// findBackupArtifacts returns local zip & db dump paths of the backup taken on @date.
+// missing ones are downloaded from s3 (when configured), unavailable ones are returned empty.
+// When the backup has a manifest, artifacts are picked from it & checked against its checksums
+func findBackupArtifacts(
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	date string,
+	l *logger.Logger,
+) (zipPath, dumpPath string, err error) {
+	dir := pc.DestPathFor(sc, date)
+
+	var rb *remotebackup.UlDl
+	if sc.S3User == "" || sc.S3Bucket == "" {
+		l.AddHeader("AWS s3 config unavailable, using local backup only")
+	} else if rb, err = remotebackup.New(sc.S3User, sc.S3Bucket, sc.DestPath(), 10, l); err != nil {
+		l.AddHeader("Bucket err. " + err.Error())
+		rb, err = nil, nil
+	}
+
+	m := loadRestoreManifest(dir, rb, l)
+	if m == nil {
+		zipPath, dumpPath = guessBackupArtifacts(sc, pc, date, rb, l)
+		return
+	}
+
+	for _, a := range m.Artifacts {
+		path := filepath.Join(dir, a.Name)
+
+		switch inventory.ArtifactKind(a.Name) {
+		case inventory.KindZip:
+			zipPath = path
+		case inventory.KindDb:
+			dumpPath = path
+		default:
+			continue
+		}
+
+		if exist, _ := util.IsPathExist(path); !exist && rb != nil {
+			l.AddHeader("Downloading from bucket: " + path)
+			if dlErr := rb.DownloadBackupFile(path); dlErr != nil {
+				l.Error("Download err. " + dlErr.Error())
+			}
+		}
+
+		if verifyErr := m.Verify(dir, a.Name); verifyErr != nil {
+			return "", "", util.ErrWithPrefix("Manifest check failed for "+path, verifyErr)
+		}
+		l.AddHeader("Manifest check passed: " + path)
+	}
+
+	return
+}
+
+// loadRestoreManifest loads manifest of backup @dir, downloading it from bucket when missing locally.
+// nil is returned when there is none (backups taken before manifests were introduced)
+func loadRestoreManifest(dir string, rb *remotebackup.UlDl, l *logger.Logger) *manifest.Manifest {
+	if exist, _ := util.IsPathExist(manifest.Path(dir)); !exist && rb != nil {
+		if err := rb.DownloadBackupFile(manifest.Path(dir)); err == nil {
+			l.AddHeader("Downloaded from bucket: " + manifest.Path(dir))
+		}
+	}
+
+	m, err := manifest.Load(dir)
+	if err != nil {
+		l.AddHeader("Manifest unavailable, picking artifacts by file names. " + err.Error())
+		return nil
+	}
+	return m
+}
+
+// guessBackupArtifacts finds zip & db dump of a backup without manifest by their file names
+func guessBackupArtifacts(
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	date string,
+	rb *remotebackup.UlDl,
+	l *logger.Logger,
+) (zipPath, dumpPath string) {
+	_, zipPath = pc.ZipFilePathFor(sc, date)
+
+	// dump file name depends on the DB name, so look for it
+	dumpMatches, _ := filepath.Glob(pc.DestPathFor(sc, date) + util.DS + date + "_*.sql.gz")
+	if len(dumpMatches) > 0 {
+		dumpPath = dumpMatches[0]
+	}
+
+	zipExist, _ := util.IsPathExist(zipPath)
+	if zipExist && dumpPath != "" {
+		return
+	}
+
+	if rb == nil {
+		if !zipExist {
+			zipPath = ""
+		}
+		return
+	}
+
+	if !zipExist {
+		l.AddHeader("Downloading from bucket: " + zipPath)
+		if err := rb.DownloadBackupFile(zipPath); err != nil {
+			zipPath = ""
+		}
+	}
+
+	if dumpPath == "" {
+		dumpPath = findRemoteDump(rb, pc.DestPathFor(sc, date), date, l)
+	}
+
+	return
+}
+
+// findRemoteDump looks for the db dump of @date in bucket & downloads it to @localDir, returns its local path
+func findRemoteDump(rb *remotebackup.UlDl, localDir, date string, l *logger.Logger) string {
+	objects, err := rb.ListObjectsWithPrefix(remotebackup.ObjectKey(localDir) + util.DS)
+	if err != nil {
+		return ""
+	}
+
+	pattern := remotebackup.ObjectKey(localDir + util.DS + date + "_*.sql.gz")
+	for _, o := range objects {
+		if matched, _ := filepath.Match(pattern, *o.Key); !matched {
+			continue
+		}
+
+		localPath := localDir + util.DS + filepath.Base(*o.Key)
+		l.AddHeader("Downloading from bucket: " + localPath)
+		if err = rb.DownloadToFile(*o.Key, localPath); err != nil {
+			return ""
+		}
+		return localPath
+	}
+
+	return ""
+}
+
+func restoreFiles(
+	conn *ssh.Client,
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	localZipPath, targetDir string,
+	l *logger.Logger,
+) error {
+	remoteZipPath := sc.ProjectRoot + util.DS + filepath.Base(localZipPath)
+
+	l.AddHeader(fmt.Sprintf("Uploading: %s --> %s", localZipPath, remoteZipPath))
+	_, err := server.PutFileToServer(conn, localZipPath, remoteZipPath)
+	if err == nil {
+		err = verifyUpload(conn, localZipPath, remoteZipPath, l)
+	}
+	if err != nil {
+		_, _ = tasks.DeletePath(conn, remoteZipPath)
+		return err
+	}
+
+	_, err = tasks.UnzipArchive(conn, remoteZipPath, targetDir, filepath.Base(pc.SourcePath(sc)), l)
+	if err != nil {
+		err = util.ErrWithPrefix("Unzip failed for "+remoteZipPath, err)
+	} else {
+		l.AddHeader("Files restored into " + targetDir)
+	}
+
+	// delete remote file
+	_, delErr := tasks.DeletePath(conn, remoteZipPath)
+	if delErr != nil {
+		l.Warn(fmt.Sprintf("Remote zip deletion err: %s", remoteZipPath))
+	}
+
+	return err
+}
+
+func restoreDb(
+	conn *ssh.Client,
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	localDumpPath string,
+	l *logger.Logger,
+) error {
+	remoteDumpPath := sc.ProjectRoot + util.DS + filepath.Base(localDumpPath)
+
+	l.AddHeader(fmt.Sprintf("Uploading: %s --> %s", localDumpPath, remoteDumpPath))
+	_, err := server.PutFileToServer(conn, localDumpPath, remoteDumpPath)
+	if err == nil {
+		err = verifyUpload(conn, localDumpPath, remoteDumpPath, l)
+	}
+	if err != nil {
+		_, _ = tasks.DeletePath(conn, remoteDumpPath)
+		return err
+	}
+
+	_, err = tasks.DbImportMySql(conn, sc, pc, l, remoteDumpPath)
+	if err != nil {
+		err = util.ErrWithPrefix("DB import failed for "+remoteDumpPath, err)
+	} else {
+		l.AddHeader("DB restored into " + pc.DbInfo.Name)
+	}
+
+	// delete remote file
+	_, delErr := tasks.DeletePath(conn, remoteDumpPath)
+	if delErr != nil {
+		l.Warn(fmt.Sprintf("Remote DB dump deletion err: %s", remoteDumpPath))
+	}
+
+	return err
+}
+
+// verifyUpload compares SHA-256 of @remotePath uploaded to the server with its local copy @localPath
+func verifyUpload(conn *ssh.Client, localPath, remotePath string, l *logger.Logger) error {
+	remoteSum, err := tasks.FileSha256(conn, remotePath)
+	if errors.Is(err, tasks.ErrNoSha256Tool) {
+		l.Warn("Checksum of uploaded file not verified, " + err.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = verifyLocalSha256(localPath, remoteSum); err != nil {
+		return util.ErrWithPrefix("Uploaded file "+remotePath+" differs", err)
+	}
+	l.AddHeader("Checksum verified: " + remotePath)
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/inventory"
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/manifest"
+	"github.com/apudiu/server-backup/internal/remotebackup"
+	"github.com/apudiu/server-backup/internal/util"
+	"github.com/apudiu/server-backup/internal/verify"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+const (
+	checkZip   = "zip crc"
+	checkDb    = "dump trailer"
+	checkS3    = "s3 copy"
+	checkSum   = "manifest sha256"
+	checkS3Sum = "s3 sha256"
+)
+
+type verifyResult struct {
+	server, project, date, artifact, check string
+	skipped                                bool
+	err                                    error
+}
+
+// verifyBackups checks integrity of backups, optionally narrowed down by
+// args: server, project path & backup date
+func verifyBackups(args []string) error {
+	if len(args) > 3 {
+		return errors.New("expected args: [server] [project-path] [yyyy-mm-dd]")
+	}
+
+	var serverFilter, projectFilter, dateFilter string
+	for i, filter := range []*string{&serverFilter, &projectFilter, &dateFilter} {
+		if i < len(args) {
+			*filter = args[i]
+		}
+	}
+
+	c := config.Config{}
+	c.Parse()
+
+	var results []verifyResult
+	for si := range c.Servers {
+		sc := &c.Servers[si]
+		if serverFilter != "" && !sc.Is(serverFilter) {
+			continue
+		}
+
+		srv, rb := collectInventory(sc)
+		if srv.RemoteErr != "" {
+			fmt.Println(util.ServerFailLogf("s3 listing failed for %s, skipping s3 checks. %s", srv.Name, srv.RemoteErr))
+		}
+
+		for _, p := range srv.Projects {
+			if projectFilter != "" && projectFilter != p.Path {
+				continue
+			}
+
+			for _, b := range p.Backups {
+				if dateFilter != "" && dateFilter != b.Date {
+					continue
+				}
+
+				m := backupManifest(b)
+				for _, r := range verifyBackup(b, m, rb) {
+					r.server, r.project, r.date = srv.Name, p.Path, b.Date
+					results = append(results, r)
+				}
+			}
+		}
+	}
+
+	failed := printVerifyResults(results)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+	return nil
+}
+
+// backupManifest returns manifest of the backup from local disk, nil when unavailable
+func backupManifest(b inventory.Backup) *manifest.Manifest {
+	for _, a := range b.Artifacts {
+		if a.Location == inventory.LocationRemote {
+			continue
+		}
+
+		m, err := manifest.Load(filepath.Dir(a.LocalPath))
+		if err != nil {
+			return nil
+		}
+		return m
+	}
+	return nil
+}
+
+// verifyBackup checks every artifact of the backup & artifacts listed in manifest @m (when available) exist
+func verifyBackup(b inventory.Backup, m *manifest.Manifest, rb *remotebackup.UlDl) []verifyResult {
+	var results []verifyResult
+	found := map[string]bool{}
+
+	for _, a := range b.Artifacts {
+		found[a.Name] = true
+		results = append(results, verifyArtifact(a, m, rb)...)
+	}
+
+	if m != nil {
+		for _, a := range m.Artifacts {
+			if !found[a.Name] {
+				results = append(results, verifyResult{
+					artifact: a.Name, check: checkSum,
+					err: errors.New("listed in manifest but missing in local disk & s3"),
+				})
+			}
+		}
+	}
+
+	return results
+}
+
+// verifyArtifact runs integrity checks on the local copy, checks it against manifest @m when available
+// & compares it with s3 copy when @rb is available
+func verifyArtifact(a inventory.Artifact, m *manifest.Manifest, rb *remotebackup.UlDl) []verifyResult {
+	var results []verifyResult
+
+	if a.Location == inventory.LocationRemote {
+		return append(results, verifyResult{
+			artifact: a.Name, check: "local copy", skipped: true,
+			err: errors.New("not available locally"),
+		})
+	}
+
+	switch a.Kind {
+	case inventory.KindZip:
+		results = append(results, verifyResult{artifact: a.Name, check: checkZip, err: verify.Zip(a.LocalPath)})
+	case inventory.KindDb:
+		results = append(results, verifyResult{artifact: a.Name, check: checkDb, err: verify.DbDump(a.LocalPath)})
+	}
+
+	var (
+		ma     manifest.Artifact
+		listed bool
+	)
+	if m != nil {
+		if ma, listed = m.Artifact(a.Name); listed {
+			results = append(results, verifyResult{
+				artifact: a.Name, check: checkSum,
+				err: m.Verify(filepath.Dir(a.LocalPath), a.Name),
+			})
+		}
+	}
+
+	if rb != nil && a.Location == inventory.LocationBoth {
+		results = append(results, verifyResult{
+			artifact: a.Name, check: checkS3,
+			err: rb.VerifyLocalCopy(a.LocalPath, a.RemoteSize, a.RemoteETag),
+		})
+
+		if listed {
+			results = append(results, verifyS3Sha256(rb, a, ma))
+		}
+	}
+
+	return results
+}
+
+// verifyS3Sha256 compares SHA-256 stored with s3 copy of @a to the one in its manifest @ma
+func verifyS3Sha256(rb *remotebackup.UlDl, a inventory.Artifact, ma manifest.Artifact) verifyResult {
+	r := verifyResult{artifact: a.Name, check: checkS3Sum}
+
+	sum, err := rb.ObjectSha256(remotebackup.ObjectKey(a.LocalPath))
+	switch {
+	case err != nil:
+		r.err = err
+	case sum == "":
+		r.skipped, r.err = true, errors.New("uploaded without sha256 metadata")
+	case sum != ma.Sha256:
+		r.err = fmt.Errorf("sha256 mismatch, s3 %s, manifest %s", sum, ma.Sha256)
+	}
+	return r
+}
+
+// verifyProjectBackup checks today's zip & db dump of a project right after those are taken
+func verifyProjectBackup(sc *config.ServerConfig, pc *config.ProjectConfig, l *logger.Logger) error {
+	var errs []error
+
+	_, zipPath := pc.ZipFilePath(sc)
+	if exist, _ := util.IsPathExist(zipPath); exist {
+		errs = append(errs, logVerifyResult(l, zipPath, checkZip, verify.Zip(zipPath)))
+	}
+
+	if pc.DbInfoAvailable() {
+		_, dumpPath := pc.DbDumpFilePath(sc)
+		if exist, _ := util.IsPathExist(dumpPath); exist {
+			errs = append(errs, logVerifyResult(l, dumpPath, checkDb, verify.DbDump(dumpPath)))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// logVerifyResult logs result of a check & returns the failure with context
+func logVerifyResult(l *logger.Logger, path, check string, err error) error {
+	if err != nil {
+		l.Error(util.ProjectFailLogf("Verify failed (%s): %s. %s", check, path, err.Error()))
+		return fmt.Errorf("verify failed (%s): %s. %s", check, path, err.Error())
+	}
+	l.AddHeader(fmt.Sprintf("Verify passed (%s): %s", check, path))
+	return nil
+}
+
+// printVerifyResults prints results as table & returns number of failed checks
+func printVerifyResults(results []verifyResult) (failed int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	_, _ = fmt.Fprintln(w, "SERVER\tPROJECT\tDATE\tARTIFACT\tCHECK\tRESULT\tMESSAGE")
+
+	for _, r := range results {
+		status, msg := "pass", ""
+		switch {
+		case r.skipped:
+			status, msg = "skip", r.err.Error()
+		case r.err != nil:
+			status, msg = "FAIL", r.err.Error()
+			failed++
+		}
+
+		_, _ = fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.server, r.project, r.date, r.artifact, r.check, status, msg,
+		)
+	}
+
+	return
+}
@@ -0,0 +1,127 @@
+package catalog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/apudiu/server-backup/internal/util"
+	"os"
+	"sync"
+	"time"
+)
+
+// record types
+const (
+	TypeRun      = "run"
+	TypeProject  = "project"
+	TypeArtifact = "artifact"
+	TypeUpload   = "upload"
+	TypeDeletion = "deletion"
+)
+
+// deletion locations
+const (
+	LocationLocal = "local"
+	LocationS3    = "s3"
+)
+
+// Record is an entry of the catalog, fields used depend on the type
+type Record struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	RunId string    `json:"runId,omitempty"`
+	// Start of the run, for run records
+	Start   *time.Time `json:"start,omitempty"`
+	Server  string     `json:"server,omitempty"`
+	Project string     `json:"project,omitempty"`
+	// Dir is the dated backup dir of the project
+	Dir    string `json:"dir,omitempty"`
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Path of artifact, uploaded file or deleted dir
+	Path   string `json:"path,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Sha256 string `json:"sha256,omitempty"`
+	// Location of deletion, local or s3
+	Location string `json:"location,omitempty"`
+}
+
+// Catalog keeps history of runs, projects, artifacts, uploads & deletions in a JSON lines file.
+// Records are only appended, so runs of several processes (like cron & daemon) can share the file
+type Catalog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Append adds @records to the catalog, records without time get current time
+func (c *Catalog) Append(records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var buf []byte
+	for _, r := range records {
+		if r.Time.IsZero() {
+			r.Time = time.Now()
+		}
+
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := util.CreatePath(c.path, 0755, true); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(c.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	// single write, so lines of concurrent writers don't interleave
+	_, err = f.Write(buf)
+	return errors.Join(err, f.Close())
+}
+
+// Records returns all records in the order those were added, unreadable lines are skipped
+func (c *Catalog) Records() ([]Record, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		r := Record{}
+		if json.Unmarshal(s.Bytes(), &r) == nil {
+			records = append(records, r)
+		}
+	}
+
+	return records, s.Err()
+}
+
+func (c *Catalog) Path() string {
+	return c.path
+}
+
+// Open returns the catalog kept in @path, the file is created on first append
+func Open(path string) *Catalog {
+	return &Catalog{path: path}
+}
@@ -0,0 +1,164 @@
+package catalog
+
+import (
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const StatusSuccess = "success"
+
+// Backup is state of a dated backup dir of a project, built from catalog records
+type Backup struct {
+	Dir     string
+	Server  string
+	Project string
+	// Good is set when any run backed it up successfully
+	Good bool
+	// Artifacts by cleaned path, latest record of each
+	Artifacts map[string]Record
+	// Uploaded artifact paths, cleaned
+	Uploaded      map[string]bool
+	LocalDeleted  bool
+	RemoteDeleted bool
+}
+
+// Backups returns state of every backup dir (by cleaned path) known to catalog,
+// of the project when @server & @project are given
+func (c *Catalog) Backups(server, project string) (map[string]*Backup, error) {
+	records, err := c.Records()
+	if err != nil {
+		return nil, err
+	}
+	return backups(records, server, project), nil
+}
+
+func backups(records []Record, server, project string) map[string]*Backup {
+	byDir := map[string]*Backup{}
+
+	get := func(dir string, r Record) *Backup {
+		b := byDir[dir]
+		if b == nil {
+			b = &Backup{
+				Dir: dir, Server: r.Server, Project: r.Project,
+				Artifacts: map[string]Record{}, Uploaded: map[string]bool{},
+			}
+			byDir[dir] = b
+		}
+		return b
+	}
+
+	for _, r := range records {
+		// uploads have no project, those are matched to project dirs by path
+		if server != "" && (r.Server != server || (r.Project != "" && r.Project != project)) {
+			continue
+		}
+
+		switch r.Type {
+		case TypeProject:
+			b := get(filepath.Clean(r.Dir), r)
+			b.Good = b.Good || r.Status == StatusSuccess
+		case TypeArtifact:
+			path := filepath.Clean(r.Path)
+			b := get(filepath.Dir(path), r)
+			b.Artifacts[path] = r
+			// artifact taken again needs to be uploaded again
+			delete(b.Uploaded, path)
+			// artifact taken again after deletion (same day) revives the backup
+			b.LocalDeleted, b.RemoteDeleted = false, false
+		case TypeUpload:
+			path := filepath.Clean(r.Path)
+			if b, ok := byDir[filepath.Dir(path)]; ok {
+				b.Uploaded[path] = true
+			}
+		case TypeDeletion:
+			if b, ok := byDir[filepath.Clean(r.Path)]; ok {
+				if r.Location == LocationS3 {
+					b.RemoteDeleted = true
+				} else {
+					b.LocalDeleted = true
+				}
+			}
+		}
+	}
+
+	return byDir
+}
+
+// LastSuccessful returns the latest successful project record of @server/@project
+func (c *Catalog) LastSuccessful(server, project string) (Record, bool, error) {
+	records, err := c.Records()
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Type == TypeProject && r.Server == server && r.Project == project && r.Status == StatusSuccess {
+			return r, true, nil
+		}
+	}
+	return Record{}, false, nil
+}
+
+// Runs returns run records finished after @since, only failed & partially failed ones when @failedOnly
+func (c *Catalog) Runs(since time.Time, failedOnly bool) ([]Record, error) {
+	records, err := c.Records()
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []Record
+	for _, r := range records {
+		if r.Type != TypeRun || r.Time.Before(since) {
+			continue
+		}
+		if failedOnly && r.Status == StatusSuccess {
+			continue
+		}
+		runs = append(runs, r)
+	}
+	return runs, nil
+}
+
+// ProjectsByRun returns project records grouped by their run id
+func (c *Catalog) ProjectsByRun() (map[string][]Record, error) {
+	records, err := c.Records()
+	if err != nil {
+		return nil, err
+	}
+
+	projects := map[string][]Record{}
+	for _, r := range records {
+		if r.Type == TypeProject {
+			projects[r.RunId] = append(projects[r.RunId], r)
+		}
+	}
+	return projects, nil
+}
+
+// MissingFromS3 returns artifacts of @servers not uploaded to s3 (latest record of each), except deleted backups
+func (c *Catalog) MissingFromS3(servers []string) ([]Record, error) {
+	records, err := c.Records()
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []Record
+	for _, b := range backups(records, "", "") {
+		if !slices.Contains(servers, b.Server) || b.LocalDeleted || b.RemoteDeleted {
+			continue
+		}
+
+		for path, a := range b.Artifacts {
+			if !b.Uploaded[path] {
+				missing = append(missing, a)
+			}
+		}
+	}
+
+	slices.SortFunc(missing, func(a, b Record) int {
+		return a.Time.Compare(b.Time)
+	})
+	return missing, nil
+}
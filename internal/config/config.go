@@ -3,11 +3,13 @@ package config
 import (
 	"errors"
 	"fmt"
+	"github.com/apudiu/server-backup/internal/sshconfig"
 	"github.com/apudiu/server-backup/internal/util"
 	"gopkg.in/yaml.v3"
 	"log"
 	"net"
 	"os"
+	"os/user"
 	"path/filepath"
 	"slices"
 	"strconv"
@@ -32,6 +34,17 @@ type projectEnvFileInfo struct {
 	DbNameKeyName string `yaml:"dbNameKeyName"`
 }
 
+// Schedule holds cron expressions (like "0 * * * *" or "@daily") for daemon mode.
+// Files & DB are scheduled separately, empty ones are not run by the daemon
+type Schedule struct {
+	Files string `yaml:"files"`
+	Db    string `yaml:"db"`
+	// Jitter is max random delay (like "5m") added to each run, to spread load
+	Jitter string `yaml:"jitter"`
+	// CatchUp runs missed jobs (while daemon was down) right after start
+	CatchUp *bool `yaml:"catchUp"`
+}
+
 type ProjectConfig struct {
 	Path         string             `yaml:"path"`
 	ExcludePaths []string           `yaml:"excludePaths"`
@@ -42,24 +55,350 @@ type ProjectConfig struct {
 	DbInfo projectDbInfo `yaml:"dbInfo"`
 	// keen this many copies of backup
 	BackupCopies int `yaml:"backupCopies"`
+	// check zip & db dump integrity right after backup
+	VerifyBackup bool `yaml:"verifyBackup"`
+	// tags for selecting projects from cli
+	Tags []string `yaml:"tags"`
+	// schedule for daemon mode, unspecified fields are taken from server schedule
+	Schedule Schedule `yaml:"schedule"`
+}
+
+const (
+	HostKeyStrict = "strict"
+	HostKeyTofu   = "tofu"
+)
+
+// HostKey decides how SSH host key of a server is verified
+type HostKey struct {
+	// Fingerprint pins the key, like "SHA256:..." as printed by `ssh-keygen -lf`, known hosts file is not used then
+	Fingerprint string `yaml:"fingerprint"`
+	// KnownHosts is path of known hosts file, ~/.ssh/known_hosts by default
+	KnownHosts string `yaml:"knownHosts"`
+	// Mode is "strict" (default, key must be in known hosts) or "tofu" (unknown keys are added to known hosts
+	// & trusted on first use). Changed keys are rejected in both modes
+	Mode string `yaml:"mode"`
+}
+
+// KnownHostsPath returns path of known hosts file, ~ is expanded
+func (hk HostKey) KnownHostsPath() string {
+	p := hk.KnownHosts
+	if p == "" {
+		p = "~/.ssh/known_hosts"
+	}
+
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = filepath.Join(home, p[1:])
+		}
+	}
+	return p
+}
+
+// TrustOnFirstUse reports whether unknown host keys are recorded & trusted
+func (hk HostKey) TrustOnFirstUse() bool {
+	return hk.Mode == HostKeyTofu
+}
+
+// auth methods of a server
+const (
+	// AuthAgent uses keys & certificates of ssh-agent at SSH_AUTH_SOCK
+	AuthAgent = "agent"
+	// AuthPublicKey uses private key at privateKeyPath, with its certificate if available
+	AuthPublicKey = "publickey"
+	// AuthPassword uses password, by password & keyboard-interactive auth
+	AuthPassword = "password"
+)
+
+// SshLogin holds how to log in a host by SSH
+type SshLogin struct {
+	Key string `yaml:"privateKeyPath"`
+	// Certificate is OpenSSH certificate of the private key, <privateKeyPath>-cert.pub is used when exists
+	Certificate string `yaml:"certificatePath"`
+	// AuthMethods are tried in order, by default key, agent (when SSH_AUTH_SOCK is set) & password (when set)
+	AuthMethods []string `yaml:"authMethods"`
+	User        string   `yaml:"user"`
+	Password    string   `yaml:"password"`
+	// HostKey verification settings
+	HostKey HostKey `yaml:"hostKey"`
+}
+
+// JumpHost is a bastion the server is reached through, like ProxyJump of ssh.
+// Login settings not specified are taken from the server, except pinned host key fingerprint
+type JumpHost struct {
+	// Host is ip or hostname of the jump host
+	Host string `yaml:"host"`
+	// Port is SSH port, 22 by default
+	Port     int `yaml:"port"`
+	SshLogin `yaml:",inline"`
+}
+
+const (
+	// TransferScp makes zip & DB dump as temp files in projectRoot of the server & copies those by scp
+	TransferScp = "scp"
+	// TransferSftp is like TransferScp, but interrupted downloads are resumed & progress is logged
+	TransferSftp = "sftp"
+	// TransferStream streams zip & DB dump by SSH session output to local files, no temp file in the server
+	TransferStream = "stream"
+)
+
+// Connection holds timeouts & retries of server connection, durations are like "30s" or "1h"
+type Connection struct {
+	// DialTimeout is max time to connect & log in, per jump host too. 30s by default
+	DialTimeout string `yaml:"dialTimeout"`
+	// CommandTimeout is max run time of a remote command like zip or db dump, no limit by default
+	CommandTimeout string `yaml:"commandTimeout"`
+	// KeepAlive is interval of keepalive requests detecting dead connections, 30s by default, "0" disables
+	KeepAlive string `yaml:"keepAlive"`
+	// Retries of connecting & idempotent steps (file download, env read), 3 by default
+	Retries *int `yaml:"retries"`
+	// RetryDelay is wait before first retry, doubled after each, 5s by default
+	RetryDelay string `yaml:"retryDelay"`
 }
 
 type ServerConfig struct {
-	Key            string          `yaml:"privateKeyPath"`
+	// Name identifies the server, it's the config dir & default backup dir name. Host or ip by default
+	Name string `yaml:"name"`
+	// Host is hostname or ~/.ssh/config alias of the server, ip is used when not specified
+	Host     string `yaml:"host"`
+	SshLogin `yaml:",inline"`
+	// JumpHosts are connected through in order, the last one connects to the server
+	JumpHosts      []JumpHost      `yaml:"jumpHosts"`
 	Ip             net.IP          `yaml:"ip"`
 	Port           int             `yaml:"port"`
-	User           string          `yaml:"user"`
-	Password       string          `yaml:"password"`
 	ProjectRoot    string          `yaml:"projectRoot"`
 	BackupSources  []string        `yaml:"backupSources"`
 	BackupDestPath string          `yaml:"backupDestPath"`
 	Projects       []ProjectConfig `yaml:"-"`
 	S3User         string          `yaml:"s3User"`
 	S3Bucket       string          `yaml:"s3Bucket"`
+	// tags for selecting servers from cli, applies to all projects of the server
+	Tags []string `yaml:"tags"`
+	// default schedule of server projects for daemon mode
+	Schedule Schedule `yaml:"schedule"`
+	// Connection timeouts & retries
+	Connection Connection `yaml:"connection"`
+	// Transfer is how zip & DB dump are taken from the server, "scp" (default), "sftp" or "stream"
+	Transfer string `yaml:"transfer"`
+
+	// projectsFiltered is set when only a subset of projects is selected
+	projectsFiltered bool
+	// hostName is Host resolved by ssh config
+	hostName string
+}
+
+// Webhook receives backup run summary by http POST
+type Webhook struct {
+	Url string `yaml:"url"`
+	// Format of the payload, "json" (default, full run summary) or "slack" (slack compatible text)
+	Format string `yaml:"format"`
+	// Headers are added to the request, like auth token
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Smtp sends backup run summary by email, disabled when host is empty
+type Smtp struct {
+	Host string   `yaml:"host"`
+	Port int      `yaml:"port"`
+	User string   `yaml:"user"`
+	Pass string   `yaml:"pass"`
+	From string   `yaml:"from"`
+	To   []string `yaml:"to"`
+	// Security of the connection, "starttls" (default), "tls" or "none"
+	Security string `yaml:"security"`
+}
+
+const (
+	NotifyOnFailure = "failure"
+	NotifyAlways    = "always"
+	NotifyOnChange  = "change"
+)
+
+type Notifications struct {
+	// On decides when to notify, "failure" (default), "always" or "change" (project failed or recovered)
+	On       string    `yaml:"on"`
+	Webhooks []Webhook `yaml:"webhooks"`
+	Smtp     Smtp      `yaml:"smtp"`
 }
 
 type Config struct {
-	Servers []ServerConfig `yaml:"servers"`
+	Servers       []ServerConfig `yaml:"servers"`
+	Notifications Notifications  `yaml:"notifications"`
+}
+
+// Enabled reports whether any notification channel is configured
+func (n Notifications) Enabled() bool {
+	return len(n.Webhooks) > 0 || n.Smtp.Host != ""
+}
+
+// When returns when to notify, defaults to failure
+func (n Notifications) When() string {
+	if n.On == "" {
+		return NotifyOnFailure
+	}
+	return n.On
+}
+
+// Auth returns auth methods to try in order
+func (l SshLogin) Auth() []string {
+	if len(l.AuthMethods) > 0 {
+		return l.AuthMethods
+	}
+
+	var methods []string
+	if l.Key != "" {
+		methods = append(methods, AuthPublicKey)
+	}
+	if os.Getenv("SSH_AUTH_SOCK") != "" {
+		methods = append(methods, AuthAgent)
+	}
+	if l.Password != "" {
+		methods = append(methods, AuthPassword)
+	}
+	return methods
+}
+
+// CertificatePath returns path of OpenSSH certificate of the private key, empty when unavailable
+func (l SshLogin) CertificatePath() string {
+	if l.Certificate != "" || l.Key == "" {
+		return l.Certificate
+	}
+
+	if exists, _ := util.IsPathExist(l.Key + "-cert.pub"); exists {
+		return l.Key + "-cert.pub"
+	}
+	return ""
+}
+
+// Address returns host:port of the jump host
+func (j JumpHost) Address() string {
+	port := j.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(j.Host, strconv.Itoa(port))
+}
+
+// Login returns login settings of the jump host, unspecified ones are taken from @server login
+func (j JumpHost) Login(server SshLogin) SshLogin {
+	l := j.SshLogin
+	if l.User == "" {
+		l.User = server.User
+	}
+	if l.Key == "" && l.Password == "" && len(l.AuthMethods) == 0 {
+		l.Key = server.Key
+		l.Certificate = server.Certificate
+		l.Password = server.Password
+		l.AuthMethods = server.AuthMethods
+	}
+	// fingerprint is of the server, never of the jump host
+	if l.HostKey.KnownHosts == "" {
+		l.HostKey.KnownHosts = server.HostKey.KnownHosts
+	}
+	if l.HostKey.Mode == "" {
+		l.HostKey.Mode = server.HostKey.Mode
+	}
+	return l
+}
+
+// Id returns stable name of the server, used for its config dir, backup dir, selectors & reports
+func (sc *ServerConfig) Id() string {
+	switch {
+	case sc.Name != "":
+		return sc.Name
+	case sc.Host != "":
+		return sc.Host
+	default:
+		return sc.Ip.String()
+	}
+}
+
+// Is reports whether @name is name, host or ip of the server
+func (sc *ServerConfig) Is(name string) bool {
+	return name == sc.Id() || (sc.Host != "" && name == sc.Host) || (sc.Ip != nil && name == sc.Ip.String())
+}
+
+// HostName returns host connected to, resolved from ssh config alias when available
+func (sc *ServerConfig) HostName() string {
+	switch {
+	case sc.hostName != "":
+		return sc.hostName
+	case sc.Host != "":
+		return sc.Host
+	default:
+		return sc.Ip.String()
+	}
+}
+
+// Address returns host:port of the server, port is 22 by default
+func (sc *ServerConfig) Address() string {
+	port := sc.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(sc.HostName(), strconv.Itoa(port))
+}
+
+// applySshConfig fills connection settings of the host alias not specified in servers.yml from ssh config,
+// same for jump hosts
+func (sc *ServerConfig) applySshConfig(sshc *sshconfig.Config) {
+	if sc.Host != "" {
+		h := sshc.Lookup(sc.Host)
+
+		sc.hostName = h.HostName
+		if sc.Port == 0 {
+			sc.Port, _ = strconv.Atoi(h.Port)
+		}
+		if sc.User == "" {
+			sc.User = h.User
+		}
+		if sc.User == "" {
+			// ssh logs in as local user by default
+			if u, err := user.Current(); err == nil {
+				sc.User = u.Username
+			}
+		}
+		if sc.Key == "" {
+			sc.Key = firstExisting(h.IdentityFiles)
+		}
+		if len(sc.JumpHosts) == 0 {
+			for _, j := range h.Jumps() {
+				port, _ := strconv.Atoi(j.Port)
+				sc.JumpHosts = append(sc.JumpHosts, JumpHost{Host: j.Host, Port: port, SshLogin: SshLogin{User: j.User}})
+			}
+		}
+	}
+
+	for i := range sc.JumpHosts {
+		sc.JumpHosts[i].applySshConfig(sshc)
+	}
+}
+
+// applySshConfig resolves the jump host alias by ssh config
+func (j *JumpHost) applySshConfig(sshc *sshconfig.Config) {
+	h := sshc.Lookup(j.Host)
+
+	if h.HostName != "" {
+		j.Host = h.HostName
+	}
+	if j.Port == 0 {
+		j.Port, _ = strconv.Atoi(h.Port)
+	}
+	if j.User == "" {
+		j.User = h.User
+	}
+	if j.Key == "" {
+		j.Key = firstExisting(h.IdentityFiles)
+	}
+}
+
+// firstExisting returns first of @paths which exists
+func firstExisting(paths []string) string {
+	for _, p := range paths {
+		if exists, _ := util.IsPathExist(p); exists {
+			return p
+		}
+	}
+	return ""
 }
 
 // DestPath returns main local backup dest path in which
@@ -69,12 +408,137 @@ func (sc *ServerConfig) DestPath() string {
 
 	// if dest path not specified use default
 	if p == "" {
-		p = util.BackupDir + util.DS + sc.Ip.String()
+		p = util.BackupDir + util.DS + sc.Id()
 	}
 
 	return p
 }
 
+// Streamed reports whether zip & DB dump are streamed, without temp files in the server
+func (sc *ServerConfig) Streamed() bool {
+	return sc.Transfer == TransferStream
+}
+
+// FindServer returns the server config matching @name, by name, host or ip
+func (c *Config) FindServer(name string) (*ServerConfig, bool) {
+	for i := range c.Servers {
+		if c.Servers[i].Is(name) {
+			return &c.Servers[i], true
+		}
+	}
+	return nil, false
+}
+
+// FindProject returns the project config matching @path
+func (sc *ServerConfig) FindProject(path string) (*ProjectConfig, bool) {
+	for i := range sc.Projects {
+		if sc.Projects[i].Path == path {
+			return &sc.Projects[i], true
+		}
+	}
+	return nil, false
+}
+
+// Selector selects servers & projects, empty or "*" fields match all
+type Selector struct {
+	Server  string
+	Project string
+}
+
+// ParseSelector parses selector like "192.168.0.100", "web-1/order-online" or "*/order-online".
+// Server is matched by its name, host or ip
+func ParseSelector(s string) (Selector, error) {
+	srv, project, _ := strings.Cut(s, "/")
+	if srv == "" {
+		return Selector{}, errors.New("invalid selector " + s + ", expected <server>[/<project>]")
+	}
+
+	if srv == "*" {
+		srv = ""
+	}
+	if project == "*" {
+		project = ""
+	}
+
+	return Selector{Server: srv, Project: project}, nil
+}
+
+func (s Selector) matches(sc *ServerConfig, pc *ProjectConfig) bool {
+	if s.Server != "" && !sc.Is(s.Server) {
+		return false
+	}
+	return s.Project == "" || s.Project == pc.Path
+}
+
+// Select keeps only projects matching any of @selectors & any of @tags (empty ones match all).
+// Servers without any selected project are removed. Errors when nothing is selected
+func (c *Config) Select(selectors []Selector, tags []string) error {
+	if len(selectors) == 0 && len(tags) == 0 {
+		return nil
+	}
+
+	var servers []ServerConfig
+	for _, sc := range c.Servers {
+		var projects []ProjectConfig
+
+		for pi := range sc.Projects {
+			pc := &sc.Projects[pi]
+
+			selected := len(selectors) == 0
+			for _, s := range selectors {
+				if s.matches(&sc, pc) {
+					selected = true
+					break
+				}
+			}
+
+			if selected && len(tags) > 0 {
+				selected = slices.ContainsFunc(tags, func(t string) bool {
+					return slices.Contains(sc.Tags, t) || slices.Contains(pc.Tags, t)
+				})
+			}
+
+			if selected {
+				projects = append(projects, *pc)
+			}
+		}
+
+		if len(projects) == 0 {
+			continue
+		}
+
+		sc.projectsFiltered = len(projects) != len(sc.Projects)
+		sc.Projects = projects
+		servers = append(servers, sc)
+	}
+
+	if len(servers) == 0 {
+		return errors.New("no server or project matches the selection")
+	}
+
+	c.Servers = servers
+	return nil
+}
+
+// ProjectsFiltered reports whether only a subset of server projects is selected
+func (sc *ServerConfig) ProjectsFiltered() bool {
+	return sc.projectsFiltered
+}
+
+// SelectedDestPaths returns local backup dirs of selected projects when only a subset of
+// projects is selected, nil when all are selected (whole DestPath is in use)
+func (sc *ServerConfig) SelectedDestPaths() []string {
+	if !sc.projectsFiltered {
+		return nil
+	}
+
+	paths := make([]string, 0, len(sc.Projects))
+	for pi := range sc.Projects {
+		paths = append(paths, filepath.Dir(sc.Projects[pi].DestPath(sc)))
+	}
+	return paths
+}
+
 // Parse parses configs for all servers and projects under them
 func (c *Config) Parse() {
 	if exists, _ := util.IsPathExist(util.ServerConfigFle); !exists {
@@ -87,10 +551,18 @@ func (c *Config) Parse() {
 	unmarshalErr := yaml.Unmarshal(sb, c)
 	util.FailIfErr(unmarshalErr)
 
+	// host aliases are resolved like ssh does
+	sshc, sshcErr := sshconfig.Load(sshconfig.DefaultPath())
+	if sshcErr != nil {
+		log.Println("Ignoring invalid ssh config. " + sshcErr.Error())
+	}
+
 	// load server projects
 	for srvIdx := range c.Servers {
 		server := &c.Servers[srvIdx]
-		configFileDir := util.ConfigDir + util.DS + server.Ip.String() + util.DS
+		server.applySshConfig(sshc)
+
+		configFileDir := util.ConfigDir + util.DS + server.Id() + util.DS
 
 		for _, sourceDir := range server.BackupSources {
 			projectConfigFile := configFileDir + sourceDir + ".yml"
@@ -165,15 +637,20 @@ func (pc *ProjectConfig) SourcePath(sc *ServerConfig) string {
 
 // DestPath returns project local absolute path
 func (pc *ProjectConfig) DestPath(sc *ServerConfig) string {
+	return pc.DestPathFor(sc, time.Now().Format(time.DateOnly))
+}
+
+// DestPathFor returns project local absolute path of the backup taken on @date (yyyy-mm-dd)
+func (pc *ProjectConfig) DestPathFor(sc *ServerConfig, date string) string {
 	p := sc.DestPath()
 
 	// if dest path doesn't contain trailing slash, add that
 	lc := p[len(p)-1:]
-	if lc != "/" || lc != util.DS {
+	if lc != "/" && lc != util.DS {
 		p += util.DS
 	}
 
-	return p + pc.Path + util.DS + time.Now().Format(time.DateOnly) // source/path/project/path
+	return p + pc.Path + util.DS + date // source/path/project/path
 }
 
 // LogFilePath returns local log file path
@@ -185,11 +662,15 @@ func (pc *ProjectConfig) LogFilePath(sc *ServerConfig) string {
 // like: /path/to/server/path/to/project/2024-12-17_120925_db_name.sql.gz
 // and: ./path/to/backup/dir/2024-12-17_120925_db_name.sql.gz
 func (pc *ProjectConfig) DbDumpFilePath(sc *ServerConfig) (remotePath, localPath string) {
-	f := time.Now().Format(time.DateOnly)
-	f += "_" + pc.DbInfo.Name + ".sql.gz"
+	return pc.DbDumpFilePathFor(sc, time.Now().Format(time.DateOnly))
+}
+
+// DbDumpFilePathFor is like DbDumpFilePath but for the backup taken on @date (yyyy-mm-dd)
+func (pc *ProjectConfig) DbDumpFilePathFor(sc *ServerConfig, date string) (remotePath, localPath string) {
+	f := date + "_" + pc.DbInfo.Name + ".sql.gz"
 
 	remotePath = sc.ProjectRoot + util.DS + f
-	localPath = pc.DestPath(sc) + util.DS + f
+	localPath = pc.DestPathFor(sc, date) + util.DS + f
 	return
 }
 
@@ -197,17 +678,94 @@ func (pc *ProjectConfig) DbDumpFilePath(sc *ServerConfig) (remotePath, localPath
 // like: /path/to/server/path/to/project/2024-12-17_120925_db_name.sql.gz
 // and: path/to/local/2024-12-17_120925_db_name.sql.gz
 func (pc *ProjectConfig) ZipFilePath(sc *ServerConfig) (remotePath, localPath string) {
-	f := time.Now().Format(time.DateOnly)
+	return pc.ZipFilePathFor(sc, time.Now().Format(time.DateOnly))
+}
+
+// ZipFilePathFor is like ZipFilePath but for the backup taken on @date (yyyy-mm-dd)
+func (pc *ProjectConfig) ZipFilePathFor(sc *ServerConfig, date string) (remotePath, localPath string) {
+	f := date
 	f += "_"
 	f += strings.Trim(pc.Path, " ")
 	f = strings.ReplaceAll(f, " ", "-")
 	f += ".zip"
 
 	remotePath = sc.ProjectRoot + util.DS + f
-	localPath = pc.DestPath(sc) + util.DS + f
+	localPath = pc.DestPathFor(sc, date) + util.DS + f
 	return
 }
 
+// DbInfoAvailable reports whether enough DB info is present to dump or import the DB
+func (pc *ProjectConfig) DbInfoAvailable() bool {
+	return pc.DbInfo.Host != nil && pc.DbInfo.Port != 0 && pc.DbInfo.User != "" && pc.DbInfo.Pass != "" && pc.DbInfo.Name != ""
+}
+
+// ScheduleFor returns project schedule, with unspecified fields taken from server schedule
+func (pc *ProjectConfig) ScheduleFor(sc *ServerConfig) Schedule {
+	s := pc.Schedule
+	if s.Files == "" {
+		s.Files = sc.Schedule.Files
+	}
+	if s.Db == "" {
+		s.Db = sc.Schedule.Db
+	}
+	if s.Jitter == "" {
+		s.Jitter = sc.Schedule.Jitter
+	}
+	if s.CatchUp == nil {
+		s.CatchUp = sc.Schedule.CatchUp
+	}
+	return s
+}
+
+// JitterDuration returns parsed jitter, zero when not specified
+func (s Schedule) JitterDuration() (time.Duration, error) {
+	if s.Jitter == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s.Jitter)
+}
+
+// CatchUpEnabled reports whether missed runs should be caught up, enabled by default
+func (s Schedule) CatchUpEnabled() bool {
+	return s.CatchUp == nil || *s.CatchUp
+}
+
+// durationOr returns parsed duration @s, @def when not specified or invalid (reported by validation)
+func durationOr(s string, def time.Duration) time.Duration {
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// Dial returns max time to connect & log in a host
+func (c Connection) Dial() time.Duration {
+	return durationOr(c.DialTimeout, 30*time.Second)
+}
+
+// Command returns max run time of a remote command, zero means no limit
+func (c Connection) Command() time.Duration {
+	return durationOr(c.CommandTimeout, 0)
+}
+
+// KeepAliveInterval returns interval of keepalive requests, zero means disabled
+func (c Connection) KeepAliveInterval() time.Duration {
+	return durationOr(c.KeepAlive, 30*time.Second)
+}
+
+// Backoff returns retry policy of connecting & idempotent steps
+func (c Connection) Backoff() util.Backoff {
+	retries := 3
+	if c.Retries != nil {
+		retries = *c.Retries
+	}
+	return util.Backoff{Attempts: retries + 1, Delay: durationOr(c.RetryDelay, 5*time.Second)}
+}
+
 // BackupCopiesCount returns number of backup copies to keep
 func (pc *ProjectConfig) BackupCopiesCount() int {
 	if pc.BackupCopies > 0 {
@@ -216,17 +774,44 @@ func (pc *ProjectConfig) BackupCopiesCount() int {
 	return util.BackupCopies
 }
 
-// GetDeletionList returns list of backup directories that should be deleted
-// to keep last n backups
-func (pc *ProjectConfig) GetDeletionList(sc *ServerConfig) []string {
+// GetDeletionList returns list of backup directories that should be deleted to keep last n good backups.
+// @known are backup dirs known from elsewhere (like ones left only in s3), @good reports whether
+// a backup dir is usable, all are taken as good when nil. Newer backups than the last kept good one
+// are never deleted, so failed runs don't push good backups out
+func (pc *ProjectConfig) GetDeletionList(sc *ServerConfig, known []string, good func(dir string) bool) []string {
+	backups, err := pc.backupDirs(sc)
+	if err != nil && len(known) == 0 {
+		fmt.Println("list err", err.Error())
+		return nil
+	}
+
+	return pc.extraBackups(mergeDirs(backups, known), good)
+}
+
+// PlannedDeletionList is like GetDeletionList but counts today's backup as taken,
+// so it tells what will be deleted after a backup run
+func (pc *ProjectConfig) PlannedDeletionList(sc *ServerConfig, known []string, good func(dir string) bool) []string {
+	backups, _ := pc.backupDirs(sc)
+
+	todayDir := filepath.Dir(pc.DestPath(sc)) + util.DS + time.Now().Format(time.DateOnly)
+	backups = mergeDirs(backups, append(known, todayDir))
+
+	isGood := func(dir string) bool {
+		return dir == filepath.Clean(todayDir) || good == nil || good(dir)
+	}
+
+	return pc.extraBackups(backups, isGood)
+}
+
+// backupDirs returns all local backup directories of the project
+func (pc *ProjectConfig) backupDirs(sc *ServerConfig) ([]string, error) {
 	projectBackupDir := filepath.Dir(pc.DestPath(sc))
 
 	var backups []string
 
 	entries, err := os.ReadDir(projectBackupDir)
 	if err != nil {
-		fmt.Println("list err", err.Error())
-		return nil
+		return nil, err
 	}
 
 	for _, entry := range entries {
@@ -243,11 +828,24 @@ func (pc *ProjectConfig) GetDeletionList(sc *ServerConfig) []string {
 		backups = append(backups, rmDir)
 	}
 
-	// specified backup copies to keep
-	keepCount := util.BackupCopies
-	if pc.BackupCopies > 0 {
-		keepCount = pc.BackupCopies
+	return backups, nil
+}
+
+// mergeDirs returns unique cleaned dirs of both lists
+func mergeDirs(a, b []string) []string {
+	var merged []string
+	for _, d := range append(slices.Clone(a), b...) {
+		if d = filepath.Clean(d); !slices.Contains(merged, d) {
+			merged = append(merged, d)
+		}
 	}
+	return merged
+}
+
+// extraBackups returns @backups older than the last good one to keep
+func (pc *ProjectConfig) extraBackups(backups []string, good func(dir string) bool) []string {
+	// specified backup copies to keep
+	keepCount := pc.BackupCopiesCount()
 
 	// do not continue if there's no extra backup
 	if len(backups) <= keepCount {
@@ -257,7 +855,17 @@ func (pc *ProjectConfig) GetDeletionList(sc *ServerConfig) []string {
 	slices.Sort(backups)
 	slices.Reverse(backups)
 
-	return backups[keepCount:]
+	kept := 0
+	for i, b := range backups {
+		if kept == keepCount {
+			return backups[i:]
+		}
+		if good == nil || good(b) {
+			kept++
+		}
+	}
+
+	return nil
 }
 
 // GenerateEmptyConfigFile generates sample config files
@@ -276,7 +884,7 @@ func GenerateEmptyConfigFile() {
 	} else {
 		// Config dir exist
 		if configDirExist, _ := util.IsPathExist(util.ConfigDir); !configDirExist {
-			err := os.Mkdir(util.ConfigDir, 0755)
+			err := os.MkdirAll(util.ConfigDir, 0755)
 			util.FailIfErr(err, "Config dir creation err")
 		}
 	}
@@ -341,11 +949,14 @@ func GenerateEmptyConfigFile() {
 	servers := Config{
 		Servers: []ServerConfig{
 			{
-				Key:            util.MakeAbsoluteFilePath("home", "user", "serverKey.pem"),
+				SshLogin: SshLogin{
+					Key:      util.MakeAbsoluteFilePath("home", "user", "serverKey.pem"),
+					User:     "privilegedUserWhoCanDoYourTasks",
+					Password: "123456",
+					HostKey:  HostKey{Mode: HostKeyStrict},
+				},
 				Ip:             net.IP{192, 168, 0, 100},
 				Port:           22,
-				User:           "privilegedUserWhoCanDoYourTasks",
-				Password:       "123456",
 				ProjectRoot:    util.MakeAbsoluteFilePath("var", "www", "php80"),
 				BackupDestPath: "",
 				BackupSources:  []string{},
@@ -371,7 +982,7 @@ func GenerateEmptyConfigFile() {
 		util.FailIfErr(errS)
 
 		// create dir if not exist
-		projectDir := util.ConfigDir + util.DS + servers.Servers[0].Ip.String()
+		projectDir := util.ConfigDir + util.DS + servers.Servers[0].Id()
 		if projectDirExist, _ := util.IsPathExist(projectDir); !projectDirExist {
 			err2 := os.MkdirAll(projectDir, 0755)
 			util.FailIfErr(err2, "Project Config dir creation err: "+projectDir)
@@ -0,0 +1,593 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/util"
+	"github.com/robfig/cron/v3"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Problem is a config issue found by Validate
+type Problem struct {
+	File     string
+	Line     int
+	Severity string
+	Msg      string
+}
+
+func (p Problem) String() string {
+	loc := p.File
+	if p.Line > 0 {
+		loc += ":" + strconv.Itoa(p.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s", loc, p.Severity, p.Msg)
+}
+
+// problems collects problems of a single file
+type problems struct {
+	file string
+	list []Problem
+}
+
+func (ps *problems) add(n *yaml.Node, severity, format string, a ...any) {
+	line := 0
+	if n != nil {
+		line = n.Line
+	}
+	ps.list = append(ps.list, Problem{File: ps.file, Line: line, Severity: severity, Msg: fmt.Sprintf(format, a...)})
+}
+
+func (ps *problems) errorf(n *yaml.Node, format string, a ...any) {
+	ps.add(n, SeverityError, format, a...)
+}
+
+func (ps *problems) warnf(n *yaml.Node, format string, a ...any) {
+	ps.add(n, SeverityWarning, format, a...)
+}
+
+// yamlErrLine matches lines of yaml errors like "line 5: field foo not found in type config.ProjectConfig"
+var yamlErrLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// Validate strictly loads servers config & every project config & reports all problems found,
+// unlike Parse it doesn't stop on the first one
+func Validate() []Problem {
+	ps := &problems{file: util.ServerConfigFle}
+
+	root, ok := loadStrict(ps, &Config{})
+	if !ok {
+		return ps.list
+	}
+
+	servers := mappingValue(root, "servers")
+	if servers == nil || servers.Kind != yaml.SequenceNode || len(servers.Content) == 0 {
+		ps.errorf(root, "no servers defined")
+		return ps.list
+	}
+
+	validateNotifications(ps, mappingValue(root, "notifications"))
+
+	seenNames := map[string]int{}
+	var all []Problem
+
+	for _, sn := range servers.Content {
+		name, sourcesNode := validateServer(ps, sn, seenNames)
+		if name == "" || sourcesNode == nil {
+			continue
+		}
+
+		for _, src := range sourcesNode.Content {
+			projectFile := util.ConfigDir + util.DS + name + util.DS + src.Value + ".yml"
+			if exist, _ := util.IsPathExist(projectFile); !exist {
+				ps.errorf(src, "backup source %q has no project config at %s", src.Value, projectFile)
+				continue
+			}
+			all = append(all, validateProject(projectFile, src.Value)...)
+		}
+	}
+
+	return append(ps.list, all...)
+}
+
+// loadStrict decodes file with unknown field detection in @out & returns the document node
+func loadStrict(ps *problems, out any) (*yaml.Node, bool) {
+	b, err := os.ReadFile(ps.file)
+	if err != nil {
+		ps.errorf(nil, "can not read file. %s", err.Error())
+		return nil, false
+	}
+
+	doc := &yaml.Node{}
+	if err = yaml.Unmarshal(b, doc); err != nil {
+		addYamlErr(ps, err)
+		return nil, false
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		ps.errorf(doc, "expected a yaml mapping")
+		return nil, false
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err = dec.Decode(out); err != nil {
+		addYamlErr(ps, err)
+	}
+
+	return doc.Content[0], true
+}
+
+func addYamlErr(ps *problems, err error) {
+	var te *yaml.TypeError
+	if !errors.As(err, &te) {
+		msgs := strings.Split(err.Error(), "\n")
+		addYamlErrLine(ps, msgs[0])
+		return
+	}
+
+	for _, e := range te.Errors {
+		addYamlErrLine(ps, e)
+	}
+}
+
+func addYamlErrLine(ps *problems, msg string) {
+	m := yamlErrLine.FindStringSubmatch(strings.TrimSpace(msg))
+	if m == nil {
+		ps.errorf(nil, "%s", msg)
+		return
+	}
+
+	line, _ := strconv.Atoi(m[1])
+	ps.errorf(&yaml.Node{Line: line}, "%s", m[2])
+}
+
+// validateServer checks a server entry & returns its name (like ServerConfig.Id) & backup sources node when usable
+func validateServer(ps *problems, sn *yaml.Node, seenNames map[string]int) (string, *yaml.Node) {
+	if sn.Kind != yaml.MappingNode {
+		ps.errorf(sn, "server entry must be a mapping")
+		return "", nil
+	}
+
+	ipNode := mappingValue(sn, "ip")
+	ip := scalar(ipNode)
+	hostNode := mappingValue(sn, "host")
+	host := scalar(hostNode)
+	switch {
+	case ip == "" && host == "":
+		ps.errorf(sn, "ip or host is required")
+	case ip != "" && net.ParseIP(ip) == nil:
+		ps.errorf(ipNode, "invalid ip %q", ip)
+		ip = ""
+	case host != "" && strings.ContainsAny(host, " \t/@"):
+		ps.errorf(hostNode, "invalid host %q, expected hostname or ssh config alias", host)
+	}
+
+	nameNode := mappingValue(sn, "name")
+	name := scalar(nameNode)
+	if name != "" && (strings.ContainsAny(name, `/\`) || name == "." || name == "..") {
+		ps.errorf(nameNode, "invalid name %q, it's used as dir name", name)
+		name = ""
+	}
+
+	// name falls back to host or ip, like ServerConfig.Id
+	if nameNode == nil {
+		name, nameNode = host, hostNode
+		if name == "" {
+			name, nameNode = ip, ipNode
+		}
+	}
+	if name != "" {
+		if line, seen := seenNames[name]; seen {
+			ps.errorf(nameNode, "duplicate server %s, already defined at line %d, set distinct names", name, line)
+		}
+		seenNames[name] = nameNode.Line
+	}
+
+	// host aliases can take port & user from ssh config
+	validatePort(ps, sn, host == "")
+
+	if host == "" && scalar(mappingValue(sn, "user")) == "" {
+		ps.errorf(sn, "user is required")
+	}
+
+	// host aliases can take key from ssh config
+	validateKey(ps, sn, host == "")
+	validateJumpHosts(ps, mappingValue(sn, "jumpHosts"))
+
+	rootNode := mappingValue(sn, "projectRoot")
+	if root := scalar(rootNode); root == "" {
+		ps.errorf(sn, "projectRoot is required")
+	} else if !strings.HasPrefix(root, "/") {
+		ps.errorf(rootNode, "projectRoot %q must be an absolute path", root)
+	}
+
+	s3User, s3Bucket := scalar(mappingValue(sn, "s3User")), scalar(mappingValue(sn, "s3Bucket"))
+	if (s3User == "") != (s3Bucket == "") {
+		ps.errorf(sn, "s3User & s3Bucket must be specified together")
+	}
+
+	validateSchedule(ps, mappingValue(sn, "schedule"))
+	validateHostKey(ps, mappingValue(sn, "hostKey"))
+	validateConnection(ps, mappingValue(sn, "connection"))
+
+	if n := mappingValue(sn, "transfer"); scalar(n) != "" && !slices.Contains([]string{TransferScp, TransferSftp, TransferStream}, n.Value) {
+		ps.errorf(n, "invalid transfer %q, expected scp, sftp or stream", n.Value)
+	}
+
+	sourcesNode := mappingValue(sn, "backupSources")
+	if sourcesNode == nil || sourcesNode.Kind != yaml.SequenceNode || len(sourcesNode.Content) == 0 {
+		ps.errorf(sn, "backupSources must list at least one project")
+		return name, nil
+	}
+
+	seenSources := map[string]bool{}
+	for _, src := range sourcesNode.Content {
+		if src.Value == "" || strings.ContainsAny(src.Value, `/\`) {
+			ps.errorf(src, "backup source %q must be a dir name directly under projectRoot", src.Value)
+		}
+		if seenSources[src.Value] {
+			ps.errorf(src, "duplicate backup source %q", src.Value)
+		}
+		seenSources[src.Value] = true
+	}
+
+	return name, sourcesNode
+}
+
+func validatePort(ps *problems, sn *yaml.Node, required bool) {
+	portNode := mappingValue(sn, "port")
+	if portNode == nil {
+		if required {
+			ps.errorf(sn, "port is required")
+		}
+		return
+	}
+
+	port, err := strconv.Atoi(portNode.Value)
+	if err != nil || port < 1 || port > 65535 {
+		ps.errorf(portNode, "invalid port %q, expected 1-65535", portNode.Value)
+	}
+}
+
+// validateKey checks auth methods are usable, private key exists & parses (with password when encrypted)
+// & certificate parses. Some auth is @required unless taken from elsewhere, like jump hosts from their server
+func validateKey(ps *problems, sn *yaml.Node, required bool) {
+	keyNode := mappingValue(sn, "privateKeyPath")
+	keyPath := scalar(keyNode)
+	password := scalar(mappingValue(sn, "password"))
+
+	methodsNode := mappingValue(sn, "authMethods")
+	var methods []string
+	if methodsNode != nil {
+		if methodsNode.Kind != yaml.SequenceNode {
+			ps.errorf(methodsNode, "authMethods must be a list")
+		} else {
+			for _, mn := range methodsNode.Content {
+				switch mn.Value {
+				case AuthPublicKey:
+					if keyPath == "" {
+						ps.errorf(mn, "publickey auth requires privateKeyPath")
+					}
+				case AuthPassword:
+					if password == "" {
+						ps.errorf(mn, "password auth requires password")
+					}
+				case AuthAgent:
+				default:
+					ps.errorf(mn, "invalid auth method %q, expected agent, publickey or password", mn.Value)
+				}
+				methods = append(methods, mn.Value)
+			}
+		}
+	}
+
+	if required && len(methods) == 0 && keyPath == "" && password == "" {
+		if os.Getenv("SSH_AUTH_SOCK") == "" {
+			ps.errorf(sn, "privateKeyPath, password or authMethods with agent is required")
+		} else {
+			ps.warnf(sn, "neither privateKeyPath nor password is specified, only ssh-agent will be used")
+		}
+	}
+
+	if slices.Contains(methods, AuthAgent) && os.Getenv("SSH_AUTH_SOCK") == "" {
+		ps.warnf(methodsNode, "agent auth is configured but SSH_AUTH_SOCK is not set")
+	}
+
+	certNode := mappingValue(sn, "certificatePath")
+	if certPath := scalar(certNode); certPath != "" {
+		validateCertificate(ps, certNode, certPath)
+	}
+
+	if keyPath == "" {
+		return
+	}
+
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		ps.errorf(keyNode, "private key %s is not readable. %s", keyPath, err.Error())
+		return
+	}
+
+	_, err = ssh.ParsePrivateKey(key)
+	var missingPass *ssh.PassphraseMissingError
+	if errors.As(err, &missingPass) {
+		_, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(password))
+	}
+	if err != nil {
+		ps.errorf(keyNode, "private key %s can not be parsed. %s", keyPath, err.Error())
+	}
+}
+
+// validateCertificate checks OpenSSH certificate at @path parses & isn't expired
+func validateCertificate(ps *problems, n *yaml.Node, path string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		ps.errorf(n, "certificate %s is not readable. %s", path, err.Error())
+		return
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		ps.errorf(n, "certificate %s can not be parsed. %s", path, err.Error())
+		return
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		ps.errorf(n, "%s is a public key, not a certificate", path)
+		return
+	}
+
+	if cert.ValidBefore != ssh.CertTimeInfinity && time.Now().Unix() >= int64(cert.ValidBefore) {
+		ps.warnf(n, "certificate %s expired at %s", path, time.Unix(int64(cert.ValidBefore), 0).Format(time.DateTime))
+	}
+}
+
+// validateJumpHosts checks jump host entries, their login settings are optional
+func validateJumpHosts(ps *problems, jn *yaml.Node) {
+	if jn == nil {
+		return
+	}
+	if jn.Kind != yaml.SequenceNode {
+		ps.errorf(jn, "jumpHosts must be a list")
+		return
+	}
+
+	for _, hn := range jn.Content {
+		if hn.Kind != yaml.MappingNode {
+			ps.errorf(hn, "jump host entry must be a mapping")
+			continue
+		}
+
+		if scalar(mappingValue(hn, "host")) == "" {
+			ps.errorf(hn, "jump host requires host")
+		}
+
+		validatePort(ps, hn, false)
+
+		validateKey(ps, hn, false)
+		validateHostKey(ps, mappingValue(hn, "hostKey"))
+	}
+}
+
+// validateHostKey checks host key verification settings
+func validateHostKey(ps *problems, hn *yaml.Node) {
+	if hn == nil {
+		return
+	}
+
+	modeNode := mappingValue(hn, "mode")
+	if mode := scalar(modeNode); mode != "" && mode != HostKeyStrict && mode != HostKeyTofu {
+		ps.errorf(modeNode, "invalid hostKey mode %q, expected strict or tofu", mode)
+	}
+
+	fpNode := mappingValue(hn, "fingerprint")
+	if fp := scalar(fpNode); fp != "" && !strings.HasPrefix(fp, "SHA256:") {
+		ps.errorf(fpNode, "invalid hostKey fingerprint %q, expected SHA256:... as printed by ssh-keygen -lf", fp)
+	}
+}
+
+// validateProject strictly loads a project config & checks its values
+func validateProject(file, source string) []Problem {
+	ps := &problems{file: file}
+
+	root, ok := loadStrict(ps, &ProjectConfig{})
+	if !ok {
+		return ps.list
+	}
+
+	pathNode := mappingValue(root, "path")
+	if path := scalar(pathNode); path == "" {
+		ps.errorf(root, "path is required")
+	} else if path != source {
+		ps.warnf(pathNode, "path %q differs from backup source name %q", path, source)
+	}
+
+	if excludes := mappingValue(root, "excludePaths"); excludes != nil {
+		for _, e := range excludes.Content {
+			validateExclude(ps, e)
+		}
+	}
+
+	validateDbConfig(ps, root)
+	validateSchedule(ps, mappingValue(root, "schedule"))
+
+	if copiesNode := mappingValue(root, "backupCopies"); copiesNode != nil {
+		if copies, err := strconv.Atoi(copiesNode.Value); err != nil || copies < 0 {
+			ps.errorf(copiesNode, "invalid backupCopies %q, expected 0 or a positive number", copiesNode.Value)
+		}
+	}
+
+	return ps.list
+}
+
+// validateExclude checks an exclude path is relative to the project & usable in zip's exclude list
+func validateExclude(ps *problems, e *yaml.Node) {
+	p := e.Value
+
+	switch {
+	case strings.TrimSpace(p) == "":
+		ps.errorf(e, "empty exclude path")
+	case strings.HasPrefix(p, "/"):
+		ps.errorf(e, "exclude path %q must be relative to the project dir", p)
+	case slices.Contains(strings.Split(p, "/"), ".."):
+		ps.errorf(e, "exclude path %q must not contain \"..\"", p)
+	case strings.ContainsAny(p, "\"\n\r"):
+		ps.errorf(e, "exclude path %q must not contain quotes or line breaks", p)
+	case strings.HasSuffix(p, "/"):
+		ps.warnf(e, "exclude path %q ends with \"/\", use \"%s*\" to exclude the dir contents", p, p)
+	}
+}
+
+// validateDbConfig checks env file keys & DB info
+func validateDbConfig(ps *problems, root *yaml.Node) {
+	envNode := mappingValue(root, "envFileInfo")
+	envPath := scalar(mappingValue(envNode, "path"))
+
+	if envPath != "" {
+		if strings.HasPrefix(envPath, "/") {
+			ps.errorf(mappingValue(envNode, "path"), "env file path %q must be relative to the project dir", envPath)
+		}
+
+		for _, k := range []string{"dbHostKeyName", "dbPortKeyName", "dbUserKeyName", "dbPassKeyName", "dbNameKeyName"} {
+			if scalar(mappingValue(envNode, k)) == "" {
+				ps.errorf(envNode, "envFileInfo.%s is required when env file path is specified", k)
+			}
+		}
+		return
+	}
+
+	dbNode := mappingValue(root, "dbInfo")
+	set := 0
+	keys := []string{"hostIp", "port", "user", "pass", "name"}
+	for _, k := range keys {
+		if v := scalar(mappingValue(dbNode, k)); v != "" && v != "0" {
+			set++
+		}
+	}
+
+	if hostNode := mappingValue(dbNode, "hostIp"); scalar(hostNode) != "" && net.ParseIP(hostNode.Value) == nil {
+		ps.errorf(hostNode, "invalid dbInfo.hostIp %q", hostNode.Value)
+	}
+
+	switch set {
+	case 0:
+		ps.warnf(root, "neither envFileInfo.path nor dbInfo is specified, DB backup will be skipped")
+	case len(keys):
+	default:
+		ps.warnf(dbNode, "dbInfo is incomplete (%s are required), DB backup will be skipped", strings.Join(keys, ", "))
+	}
+}
+
+// validateSchedule checks cron expressions & jitter of a schedule
+func validateSchedule(ps *problems, sn *yaml.Node) {
+	for _, k := range []string{"files", "db"} {
+		n := mappingValue(sn, k)
+		if expr := scalar(n); expr != "" {
+			if _, err := cron.ParseStandard(expr); err != nil {
+				ps.errorf(n, "invalid schedule.%s %q. %s", k, expr, err.Error())
+			}
+		}
+	}
+
+	if n := mappingValue(sn, "jitter"); scalar(n) != "" {
+		if _, err := time.ParseDuration(scalar(n)); err != nil {
+			ps.errorf(n, "invalid schedule.jitter %q, expected duration like 5m", n.Value)
+		}
+	}
+}
+
+// validateConnection checks timeouts & retries of server connection
+func validateConnection(ps *problems, cn *yaml.Node) {
+	for _, k := range []string{"dialTimeout", "commandTimeout", "keepAlive", "retryDelay"} {
+		n := mappingValue(cn, k)
+		if scalar(n) == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(n.Value); err != nil || d < 0 {
+			ps.errorf(n, "invalid connection.%s %q, expected duration like 30s", k, n.Value)
+		}
+	}
+
+	if n := mappingValue(cn, "retries"); scalar(n) != "" {
+		if r, err := strconv.Atoi(n.Value); err != nil || r < 0 {
+			ps.errorf(n, "invalid connection.retries %q, expected a number, 0 or more", n.Value)
+		}
+	}
+}
+
+func validateNotifications(ps *problems, nn *yaml.Node) {
+	if nn == nil {
+		return
+	}
+
+	if n := mappingValue(nn, "on"); scalar(n) != "" &&
+		!slices.Contains([]string{NotifyOnFailure, NotifyAlways, NotifyOnChange}, n.Value) {
+		ps.errorf(n, "invalid notifications.on %q, expected failure, always or change", n.Value)
+	}
+
+	if hooks := mappingValue(nn, "webhooks"); hooks != nil {
+		for _, h := range hooks.Content {
+			urlNode := mappingValue(h, "url")
+			if u := scalar(urlNode); u == "" {
+				ps.errorf(h, "webhook url is required")
+			} else if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+				ps.errorf(urlNode, "invalid webhook url %q, expected http(s) url", u)
+			}
+
+			if n := mappingValue(h, "format"); scalar(n) != "" && n.Value != "json" && n.Value != "slack" {
+				ps.errorf(n, "invalid webhook format %q, expected json or slack", n.Value)
+			}
+		}
+	}
+
+	sn := mappingValue(nn, "smtp")
+	if sn == nil || scalar(mappingValue(sn, "host")) == "" {
+		return
+	}
+
+	validatePort(ps, sn, true)
+
+	if scalar(mappingValue(sn, "from")) == "" {
+		ps.errorf(sn, "smtp from is required")
+	}
+	if to := mappingValue(sn, "to"); to == nil || len(to.Content) == 0 {
+		ps.errorf(sn, "smtp to must list at least one address")
+	}
+	if n := mappingValue(sn, "security"); scalar(n) != "" && !slices.Contains([]string{"starttls", "tls", "none"}, n.Value) {
+		ps.errorf(n, "invalid smtp security %q, expected starttls, tls or none", n.Value)
+	}
+}
+
+// mappingValue returns value node of @key in mapping node @m
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalar(n *yaml.Node) string {
+	if n == nil || n.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return strings.TrimSpace(n.Value)
+}
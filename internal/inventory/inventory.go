@@ -0,0 +1,212 @@
+package inventory
+
+import (
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/manifest"
+	"github.com/apudiu/server-backup/internal/remotebackup"
+	"github.com/apudiu/server-backup/internal/util"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	KindZip      = "zip"
+	KindDb       = "db"
+	KindLog      = "log"
+	KindManifest = "manifest"
+	KindOther    = "other"
+
+	LocationLocal  = "local"
+	LocationRemote = "remote"
+	LocationBoth   = "both"
+)
+
+type Artifact struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	LocalPath  string `json:"localPath"`
+	LocalSize  int64  `json:"localSize"`
+	RemoteKey  string `json:"remoteKey,omitempty"`
+	RemoteSize int64  `json:"remoteSize"`
+	RemoteETag string `json:"remoteETag,omitempty"`
+	Location   string `json:"location"`
+}
+
+type Backup struct {
+	Date      string     `json:"date"`
+	Location  string     `json:"location"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+type Project struct {
+	Path    string   `json:"path"`
+	Backups []Backup `json:"backups"`
+}
+
+type Server struct {
+	Name     string    `json:"name"`
+	Projects []Project `json:"projects"`
+	// RemoteErr is set when bucket listing failed, so remote info is incomplete
+	RemoteErr string `json:"remoteErr,omitempty"`
+}
+
+// ArtifactKind returns kind of backup file by its name
+func ArtifactKind(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return KindZip
+	case strings.HasSuffix(name, ".sql.gz"):
+		return KindDb
+	case strings.HasSuffix(name, ".log"):
+		return KindLog
+	case name == manifest.FileName:
+		return KindManifest
+	default:
+		return KindOther
+	}
+}
+
+// Collect builds the backup inventory of a server from its local backup dir
+// & @objects listed from its bucket (nil when s3 is not used)
+func Collect(sc *config.ServerConfig, objects []types.Object) Server {
+	// project -> date -> file name -> artifact
+	tree := map[string]map[string]map[string]*Artifact{}
+
+	get := func(project, date, name string) *Artifact {
+		if tree[project] == nil {
+			tree[project] = map[string]map[string]*Artifact{}
+		}
+		if tree[project][date] == nil {
+			tree[project][date] = map[string]*Artifact{}
+		}
+		a := tree[project][date][name]
+		if a == nil {
+			a = &Artifact{
+				Name:      name,
+				Kind:      ArtifactKind(name),
+				LocalPath: sc.DestPath() + util.DS + filepath.FromSlash(project) + util.DS + date + util.DS + name,
+			}
+			tree[project][date][name] = a
+		}
+		return a
+	}
+
+	collectLocal(sc.DestPath(), func(project, date, name string, size int64) {
+		a := get(project, date, name)
+		a.LocalSize = size
+		a.Location = LocationLocal
+	})
+
+	prefix := remotebackup.ObjectKey(sc.DestPath()) + util.DS
+	for _, o := range objects {
+		if o.Key == nil || !strings.HasPrefix(*o.Key, prefix) {
+			continue
+		}
+
+		project, date, name, ok := splitBackupPath(strings.TrimPrefix(*o.Key, prefix))
+		if !ok || util.IsPartFile(name) {
+			continue
+		}
+
+		a := get(project, date, name)
+		a.RemoteKey = *o.Key
+		if o.Size != nil {
+			a.RemoteSize = *o.Size
+		}
+		if o.ETag != nil {
+			a.RemoteETag = *o.ETag
+		}
+		if a.Location == LocationLocal {
+			a.Location = LocationBoth
+		} else {
+			a.Location = LocationRemote
+		}
+	}
+
+	srv := Server{Name: sc.Id()}
+
+	for _, project := range sortedKeys(tree) {
+		p := Project{Path: project}
+
+		for _, date := range sortedKeys(tree[project]) {
+			b := Backup{Date: date}
+
+			for _, name := range sortedKeys(tree[project][date]) {
+				a := tree[project][date][name]
+				b.Artifacts = append(b.Artifacts, *a)
+				b.Location = mergeLocation(b.Location, a.Location)
+			}
+			p.Backups = append(p.Backups, b)
+		}
+		srv.Projects = append(srv.Projects, p)
+	}
+
+	return srv
+}
+
+// collectLocal walks <destDir>/<project>/<date>/<file> & calls @cb for each file,
+// project path may have multiple dirs like apps/web
+func collectLocal(destDir string, cb func(project, date, name string, size int64)) {
+	_ = filepath.WalkDir(destDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || util.IsPartFile(d.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(destDir, path)
+		if err != nil {
+			return nil
+		}
+		project, date, name, ok := splitBackupPath(rel)
+		if !ok {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		cb(project, date, name, fi.Size())
+		return nil
+	})
+}
+
+// splitBackupPath splits @p like <project>/<date>/<file> relative to backup dir of the server. Project is everything
+// before the date, so it may have multiple dirs, those are joined by "/" like project paths in config
+func splitBackupPath(p string) (project, date, name string, ok bool) {
+	parts := strings.Split(p, util.DS)
+	if len(parts) < 3 {
+		return "", "", "", false
+	}
+
+	date, name = parts[len(parts)-2], parts[len(parts)-1]
+	if !isBackupDate(date) {
+		return "", "", "", false
+	}
+	return strings.Join(parts[:len(parts)-2], "/"), date, name, true
+}
+
+// isBackupDate checks for backup dir names, those are dates like 2024-12-17
+func isBackupDate(name string) bool {
+	_, err := time.Parse(time.DateOnly, name)
+	return err == nil
+}
+
+func mergeLocation(a, b string) string {
+	if a == "" || a == b {
+		return b
+	}
+	return LocationBoth
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -1,35 +1,147 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/apudiu/server-backup/internal/util"
 	"io"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
 
-type Logger struct {
+// formats of logs written to files, terminal output is always colored text
+const (
+	FormatText = "text"
+	FormatJson = "json"
+)
+
+var (
+	fileFormat = FormatText
+	// threshold is the lowest level logged, to terminal & files
+	threshold = LevelInfo
+)
+
+// SetFormat sets format of logs written to files by all loggers
+func SetFormat(format string) error {
+	if format != FormatText && format != FormatJson {
+		return errors.New("invalid log format " + format + ", expected text or json")
+	}
+	fileFormat = format
+	return nil
+}
+
+// SetLevel sets the lowest level logged by all loggers
+func SetLevel(level Level) {
+	threshold = level
+}
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// ParseLevel parses level name like "debug"
+func ParseLevel(name string) (Level, error) {
+	for lv := LevelDebug; lv <= LevelError; lv++ {
+		if lv.String() == name {
+			return lv, nil
+		}
+	}
+	return LevelInfo, errors.New("invalid log level " + name + ", expected debug, info, warn or error")
+}
+
+func (lv Level) String() string {
+	switch lv {
+	case LevelDebug:
+		return "debug"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// Fields are extra data of a log entry, like bytes copied
+type Fields map[string]any
+
+// entry is a log line in json format
+type entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Server  string    `json:"server,omitempty"`
+	Project string    `json:"project,omitempty"`
+	Step    string    `json:"step,omitempty"`
+	Msg     string    `json:"msg"`
+	Fields  Fields    `json:"fields,omitempty"`
+}
+
+// maxPending is the most bytes of logs kept till the log file is set, oldest lines are dropped beyond it.
+// Loggers never given a file (like of list or verify) would grow forever otherwise
+const maxPending = 1024 * 1024
+
+// colorCodes matches terminal color escape sequences, those are removed from json logs
+var colorCodes = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// sink writes logs of a logger & loggers derived from it to the log file as those arrive.
+// Logs added before the file is set are kept till then, up to maxPending bytes
+type sink struct {
 	locker  sync.Mutex
 	verbose bool
-	data    []byte
+	file    *os.File
+	pending []byte
+}
+
+// Logger collects log entries, entries carry server, project & step of the logger
+type Logger struct {
+	*sink
+	server  string
+	project string
+	step    string
 }
 
 // ToggleStdOut toggles printing the log to stdOut
 func (l *Logger) ToggleStdOut(enable bool) {
+	l.locker.Lock()
 	l.verbose = enable
+	l.locker.Unlock()
 }
 
-// Add adds to the log
-func (l *Logger) Add(b []byte) {
-	l.locker.Lock()
+// WithServer returns a logger adding to the same log, its entries carry server @ip
+func (l *Logger) WithServer(ip string) *Logger {
+	d := *l
+	d.server = ip
+	return &d
+}
 
-	if l.verbose {
-		fmt.Print(string(b))
-	}
+// WithProject returns a logger adding to the same log, its entries carry project @path
+func (l *Logger) WithProject(path string) *Logger {
+	d := *l
+	d.project = path
+	return &d
+}
 
-	l.data = append(l.data, b...)
-	l.locker.Unlock()
+// WithStep returns a logger adding to the same log, its entries carry backup @step
+func (l *Logger) WithStep(step string) *Logger {
+	d := *l
+	d.step = step
+	return &d
+}
+
+// Add adds to the log
+func (l *Logger) Add(b []byte) {
+	l.write(LevelInfo, string(b), nil, false)
 }
 
 // AddLn adds EOL as suffix
@@ -39,8 +151,39 @@ func (l *Logger) AddLn(b []byte) {
 
 // AddHeader adds a line like "[2024-12-28 15:16:17] content (@b)"
 func (l *Logger) AddHeader(s string) {
-	ts := fmt.Sprintf("[%s] %s", time.Now().Format(time.DateTime), s)
-	l.AddLn([]byte(ts))
+	l.Info(s)
+}
+
+func (l *Logger) Debug(msg string, fields ...Fields) {
+	l.Log(LevelDebug, msg, fields...)
+}
+
+func (l *Logger) Info(msg string, fields ...Fields) {
+	l.Log(LevelInfo, msg, fields...)
+}
+
+func (l *Logger) Warn(msg string, fields ...Fields) {
+	l.Log(LevelWarn, msg, fields...)
+}
+
+func (l *Logger) Error(msg string, fields ...Fields) {
+	l.Log(LevelError, msg, fields...)
+}
+
+// Log adds an entry like "[2024-12-28 15:16:17] msg key=value" (in text format),
+// log files get the level too, like "[2024-12-28 15:16:17] WARN msg key=value"
+func (l *Logger) Log(level Level, msg string, fields ...Fields) {
+	var f Fields
+	for _, fs := range fields {
+		if f == nil {
+			f = Fields{}
+		}
+		for k, v := range fs {
+			f[k] = v
+		}
+	}
+
+	l.write(level, msg, f, true)
 }
 
 // ReadStream reads from a stream & adds to the log
@@ -50,36 +193,139 @@ func (l *Logger) ReadStream(stream *io.Reader) {
 	})
 }
 
-// Print prints collected longs
-func (l *Logger) Print() {
-	fmt.Println(string(l.data))
+// ReadStreamSummarized reads from a stream, noisy lines starting with any of @prefixes (like "adding:" of zip)
+// are logged at debug level & counted, rest at info level. Returns count of the noisy lines
+func (l *Logger) ReadStreamSummarized(stream *io.Reader, prefixes ...string) int {
+	count := 0
+	util.ReadLinesFromStream(stream, func(b []byte) {
+		line := string(b)
+		trimmed := strings.TrimSpace(line)
+		for _, p := range prefixes {
+			if strings.HasPrefix(trimmed, p) {
+				count++
+				l.Debug(line)
+				return
+			}
+		}
+		l.Info(line)
+	})
+	return count
 }
 
-// WriteToFile dumps to specified file
-func (l *Logger) WriteToFile(filePath string) error {
+// write adds the entry to the log, text of entries without @header is added as is
+func (l *Logger) write(level Level, msg string, fields Fields, header bool) {
+	if level < threshold {
+		return
+	}
+
+	now := time.Now()
+
+	text := msg
+	if header {
+		text = fmt.Sprintf("[%s] %s%s%s", now.Format(time.DateTime), msg, formatFields(fields), util.Eol)
+	}
+
+	// file lines carry the level, terminal shows it by emojis & colors of messages
+	fileLine := []byte(text)
+	if header {
+		fileLine = []byte(fmt.Sprintf("[%s] %s %s%s%s", now.Format(time.DateTime), strings.ToUpper(level.String()), msg, formatFields(fields), util.Eol))
+	}
+	if fileFormat == FormatJson {
+		b, err := json.Marshal(entry{
+			Time:    now,
+			Level:   level.String(),
+			Server:  l.server,
+			Project: l.project,
+			Step:    l.step,
+			Msg:     strings.TrimRight(colorCodes.ReplaceAllString(msg, ""), "\r\n"),
+			Fields:  fields,
+		})
+		if err == nil {
+			fileLine = append(b, '\n')
+		}
+	}
+
+	l.locker.Lock()
+	defer l.locker.Unlock()
+
+	if l.verbose {
+		fmt.Print(text)
+	}
+
+	if l.file == nil {
+		l.pending = append(l.pending, fileLine...)
+		if over := len(l.pending) - maxPending; over > 0 {
+			// drop whole lines
+			cut := len(l.pending)
+			if i := bytes.IndexByte(l.pending[over:], '\n'); i >= 0 {
+				cut = over + i + 1
+			}
+			l.pending = append(l.pending[:0], l.pending[cut:]...)
+		}
+		return
+	}
+	if _, err := l.file.Write(fileLine); err != nil {
+		fmt.Println("❌ Failed to write to log file", l.file.Name(), err.Error())
+	}
+}
+
+// formatFields formats fields like " key=value" sorted by key
+func formatFields(fields Fields) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	s := ""
+	for _, k := range keys {
+		s += fmt.Sprintf(" %s=%v", k, fields[k])
+	}
+	return s
+}
+
+// SetFile sets the file logs are appended to, logs added so far are written right away
+func (l *Logger) SetFile(filePath string) error {
 	// create path if not exist
 	err := util.CreatePath(filePath, 0755, true)
 	if err != nil {
 		return err
 	}
 
-	l.locker.Lock()
-
 	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	_, err = f.Write(l.data)
-	if err != nil {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+
+	if _, err = f.Write(l.pending); err != nil {
+		_ = f.Close()
 		return err
 	}
 
-	l.locker.Unlock()
+	if l.file != nil {
+		_ = l.file.Close()
+	}
+	l.file = f
+	l.pending = nil
 	return nil
 }
 
+// Close closes the log file, if set
+func (l *Logger) Close() error {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
 func New() *Logger {
-	return &Logger{}
+	return &Logger{sink: &sink{}}
 }
@@ -0,0 +1,156 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileName of the manifest in each dated backup dir
+const FileName = "manifest.json"
+
+// Artifact is a backup file in the dir of the manifest
+type Artifact struct {
+	// Name of the file in the backup dir
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	// Verified is set when Sha256 matched the artifact in the server (or its stream) right after copying
+	Verified bool `json:"verified,omitempty"`
+	// Command run in the server to produce the artifact, secrets masked
+	Command string    `json:"command,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Manifest describes a dated backup of a project. Files & DB may be backed up by separate runs
+// in the same day (daemon mode), each run updates its artifacts in the manifest
+type Manifest struct {
+	Server       string     `json:"server"`
+	Project      string     `json:"project"`
+	Date         string     `json:"date"`
+	ToolVersion  string     `json:"toolVersion"`
+	Start        time.Time  `json:"start"`
+	End          time.Time  `json:"end"`
+	SourcePath   string     `json:"sourcePath"`
+	ExcludePaths []string   `json:"excludePaths"`
+	DbName       string     `json:"dbName,omitempty"`
+	Artifacts    []Artifact `json:"artifacts"`
+}
+
+// Path returns manifest path in backup @dir
+func Path(dir string) string {
+	return filepath.Join(dir, FileName)
+}
+
+// Load reads the manifest from backup @dir, error satisfies errors.Is(err, os.ErrNotExist) when there is none
+func Load(dir string) (*Manifest, error) {
+	b, err := os.ReadFile(Path(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s. %s", Path(dir), err.Error())
+	}
+	return m, nil
+}
+
+// Write writes the manifest in backup @dir, replacing the previous one atomically
+func (m *Manifest) Write(dir string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := Path(dir) + ".tmp"
+	if err = os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, Path(dir))
+}
+
+// Artifact returns the artifact named @name
+func (m *Manifest) Artifact(name string) (Artifact, bool) {
+	for _, a := range m.Artifacts {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Artifact{}, false
+}
+
+// AddArtifact hashes the file at @path & adds it to the manifest, replacing the previous entry of the same name.
+// @verifiedSha256 is hash checked against the server while copying, empty when unknown. File must still match it
+func (m *Manifest) AddArtifact(path, command, verifiedSha256 string) error {
+	sum, size, err := FileSha256(path)
+	if err != nil {
+		return err
+	}
+
+	if verifiedSha256 != "" && sum != verifiedSha256 {
+		return fmt.Errorf("%s changed since copied, sha256 %s, verified %s", path, sum, verifiedSha256)
+	}
+
+	a := Artifact{
+		Name:     filepath.Base(path),
+		Size:     size,
+		Sha256:   sum,
+		Verified: verifiedSha256 != "",
+		Command:  command,
+		Created:  time.Now(),
+	}
+
+	for i := range m.Artifacts {
+		if m.Artifacts[i].Name == a.Name {
+			m.Artifacts[i] = a
+			return nil
+		}
+	}
+	m.Artifacts = append(m.Artifacts, a)
+	return nil
+}
+
+// Verify checks size & SHA-256 of the artifact named @name in backup @dir against the manifest
+func (m *Manifest) Verify(dir, name string) error {
+	a, ok := m.Artifact(name)
+	if !ok {
+		return errors.New(name + " is not listed in manifest")
+	}
+
+	sum, size, err := FileSha256(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+
+	if size != a.Size {
+		return fmt.Errorf("size mismatch, manifest %d, file %d", a.Size, size)
+	}
+	if sum != a.Sha256 {
+		return fmt.Errorf("sha256 mismatch, manifest %s, file %s", a.Sha256, sum)
+	}
+	return nil
+}
+
+// FileSha256 returns hex SHA-256 & size of the file at @path
+func FileSha256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
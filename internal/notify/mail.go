@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rootCAs verify certificates of smtp servers, system roots are used when nil
+var rootCAs *x509.CertPool
+
+func sendMail(s config.Smtp, subject, body string) error {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	tlsConfig := &tls.Config{ServerName: s.Host, RootCAs: rootCAs}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.Security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	switch s.Security {
+	case "", "starttls":
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server doesn't support STARTTLS, set security to none to send without encryption")
+		}
+		if err = c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	case "tls", "none":
+	default:
+		return fmt.Errorf("unknown smtp security %q", s.Security)
+	}
+
+	// plain auth is refused by net/smtp over unencrypted connection, except for localhost
+	if s.User != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.User, s.Pass, s.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err = c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	msg := strings.Builder{}
+	msg.WriteString("From: " + s.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(s.To, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	if _, err = w.Write([]byte(msg.String())); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,237 @@
+package notify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/apudiu/server-backup/internal/config"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mail is a message received by the test smtp server
+type mail struct {
+	tls        bool
+	auth       string
+	from       string
+	to         []string
+	data       string
+	sessionErr error
+}
+
+// smtpServer starts a minimal smtp server accepting one message, STARTTLS is offered when @tlsConfig isn't nil.
+// Returns its port & a channel getting the message when the session ends
+func smtpServer(t *testing.T, tlsConfig *tls.Config) (int, <-chan mail) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan mail, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			ch <- mail{sessionErr: err}
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		var m mail
+		m.sessionErr = smtpSession(conn, tlsConfig, &m)
+		ch <- m
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port, ch
+}
+
+// smtpSession serves smtp commands of @conn, the message is written to @m
+func smtpSession(conn net.Conn, tlsConfig *tls.Config, m *mail) error {
+	tp := textproto.NewConn(conn)
+	reply := func(line string) error { return tp.PrintfLine("%s", line) }
+
+	if err := reply("220 localhost ESMTP test"); err != nil {
+		return err
+	}
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return err
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			exts := []string{"250-localhost", "250-AUTH PLAIN"}
+			if tlsConfig != nil && !m.tls {
+				exts = append(exts, "250-STARTTLS")
+			}
+			exts = append(exts, "250 8BITMIME")
+			err = reply(strings.Join(exts, "\r\n"))
+		case "STARTTLS":
+			if err = reply("220 ready to start TLS"); err != nil {
+				return err
+			}
+			tlsConn := tls.Server(conn, tlsConfig)
+			if err = tlsConn.Handshake(); err != nil {
+				return err
+			}
+			conn, tp, m.tls = tlsConn, textproto.NewConn(tlsConn), true
+			continue
+		case "AUTH":
+			_, cred, _ := strings.Cut(arg, " ")
+			b, _ := base64.StdEncoding.DecodeString(cred)
+			m.auth = string(b)
+			err = reply("235 authenticated")
+		case "MAIL":
+			m.from = pathArg(arg)
+			err = reply("250 ok")
+		case "RCPT":
+			m.to = append(m.to, pathArg(arg))
+			err = reply("250 ok")
+		case "DATA":
+			if err = reply("354 send data"); err != nil {
+				return err
+			}
+			b, readErr := tp.ReadDotBytes()
+			if readErr != nil {
+				return readErr
+			}
+			m.data = string(b)
+			err = reply("250 queued")
+		case "QUIT":
+			return reply("221 bye")
+		default:
+			err = reply("502 unknown command")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// pathArg returns the address of MAIL & RCPT args like FROM:<a@b.c> BODY=8BITMIME
+func pathArg(arg string) string {
+	_, addr, _ := strings.Cut(arg, "<")
+	addr, _, _ = strings.Cut(addr, ">")
+	return addr
+}
+
+// selfSignedTLS returns server tls config of a certificate for 127.0.0.1 & sets it trusted by sendMail
+func selfSignedTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	rootCAs = pool
+	t.Cleanup(func() { rootCAs = nil })
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func smtpConfig(port int, security string) config.Smtp {
+	return config.Smtp{
+		Host:     "127.0.0.1",
+		Port:     port,
+		From:     "backup@example.com",
+		To:       []string{"ops@example.com", "dev@example.com"},
+		Security: security,
+	}
+}
+
+// checkMail fails @t when @m isn't the message sent by smtpConfig
+func checkMail(t *testing.T, m mail) {
+	t.Helper()
+	if m.sessionErr != nil {
+		t.Fatalf("smtp session failed: %v", m.sessionErr)
+	}
+	if m.from != "backup@example.com" || strings.Join(m.to, ",") != "ops@example.com,dev@example.com" {
+		t.Errorf("got from %q to %q", m.from, m.to)
+	}
+	// line ends are read as "\n"
+	for _, want := range []string{
+		"From: backup@example.com\n",
+		"To: ops@example.com, dev@example.com\n",
+		"Subject: =?utf-8?q?=E2=9C=85_Backup_succeeded?=\n",
+		"Content-Type: text/plain; charset=UTF-8\n",
+		"\n\nline 1\nline 2",
+	} {
+		if !strings.Contains(m.data, want) {
+			t.Errorf("message lacks %q, got %q", want, m.data)
+		}
+	}
+}
+
+func TestSendMailNone(t *testing.T) {
+	port, ch := smtpServer(t, nil)
+
+	if err := sendMail(smtpConfig(port, "none"), "✅ Backup succeeded", "line 1\nline 2"); err != nil {
+		t.Fatal(err)
+	}
+	m := <-ch
+	checkMail(t, m)
+	if m.tls {
+		t.Error("connection is encrypted with security none")
+	}
+}
+
+func TestSendMailStartTls(t *testing.T) {
+	port, ch := smtpServer(t, selfSignedTLS(t))
+	s := smtpConfig(port, "starttls")
+	s.User, s.Pass = "user", "secret"
+
+	if err := sendMail(s, "✅ Backup succeeded", "line 1\nline 2"); err != nil {
+		t.Fatal(err)
+	}
+	m := <-ch
+	checkMail(t, m)
+	if !m.tls {
+		t.Error("message is sent without STARTTLS")
+	}
+	if m.auth != "\x00user\x00secret" {
+		t.Errorf("got auth %q, want plain auth of the user", m.auth)
+	}
+}
+
+func TestSendMailStartTlsUnsupported(t *testing.T) {
+	port, ch := smtpServer(t, nil)
+
+	err := sendMail(smtpConfig(port, ""), "✅ Backup succeeded", "body")
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("got error %v, want STARTTLS unsupported", err)
+	}
+	if m := <-ch; m.from != "" {
+		t.Error("message is sent without encryption")
+	}
+}
@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/report"
+	"github.com/apudiu/server-backup/internal/util"
+	"os"
+	"strings"
+	"time"
+)
+
+// Notify sends summary of @run when configured to, for every run, only on failures or
+// only when any project failed or recovered compared to the previous run (kept in @statePath)
+func Notify(cfg config.Notifications, run *report.Run, statePath string) error {
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	changed, stateErr := updateState(statePath, run)
+	if stateErr != nil {
+		stateErr = util.ErrWithPrefix("Failed to update notification state", stateErr)
+	}
+
+	switch cfg.When() {
+	case config.NotifyOnFailure:
+		if !run.Failed() {
+			return stateErr
+		}
+	case config.NotifyOnChange:
+		if !changed {
+			return stateErr
+		}
+	}
+
+	return errors.Join(stateErr, Send(cfg, run))
+}
+
+// Send sends summary of @run to every configured channel, errors of all failed channels are returned
+func Send(cfg config.Notifications, run *report.Run) error {
+	subject, body := Message(run)
+
+	var errs []error
+	for _, w := range cfg.Webhooks {
+		if err := sendWebhook(w, run, subject, body); err != nil {
+			errs = append(errs, util.ErrWithPrefix("Webhook "+w.Url+" failed", err))
+		}
+	}
+
+	if cfg.Smtp.Host != "" {
+		if err := sendMail(cfg.Smtp, subject, body); err != nil {
+			errs = append(errs, util.ErrWithPrefix("Email via "+cfg.Smtp.Host+" failed", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Message returns subject & plain text body summarizing @run
+func Message(run *report.Run) (subject, body string) {
+	failed := run.FailedProjects()
+	hostname, _ := os.Hostname()
+
+	switch {
+	case failed > 0:
+		subject = fmt.Sprintf("❌ Backup failed: %d of %d projects", failed, len(run.Projects))
+	case run.Failed():
+		subject = fmt.Sprintf("❌ Backup completed with errors: %d projects", len(run.Projects))
+	default:
+		subject = fmt.Sprintf("✅ Backup succeeded: %d projects", len(run.Projects))
+	}
+	if hostname != "" {
+		subject += " on " + hostname
+	}
+
+	b := strings.Builder{}
+	b.WriteString(fmt.Sprintf(
+		"Started: %s, took %s\n\n",
+		run.Start.Format(time.DateTime), run.End.Sub(run.Start).Round(time.Second),
+	))
+
+	for _, p := range run.Projects {
+		if errs := p.Errors(); len(errs) > 0 {
+			b.WriteString(fmt.Sprintf("❌ %s: %s\n", p.Key(), strings.Join(errs, "; ")))
+		} else {
+			b.WriteString(fmt.Sprintf("✅ %s\n", p.Key()))
+		}
+	}
+
+	for _, s := range run.Servers {
+		if errs := s.Errors(); len(errs) > 0 {
+			b.WriteString(fmt.Sprintf("❌ %s: %s\n", s.Name, strings.Join(errs, "; ")))
+		}
+	}
+
+	return subject, b.String()
+}
+
+// updateState saves failed status of projects of @run & reports whether any of those changed
+func updateState(statePath string, run *report.Run) (bool, error) {
+	state := map[string]bool{}
+	if b, err := os.ReadFile(statePath); err == nil {
+		// invalid state is treated as no state
+		_ = json.Unmarshal(b, &state)
+	}
+
+	changed := false
+	setState := func(key string, failed bool) {
+		prev, known := state[key]
+		// first failure is a change, first success is not
+		if (known && prev != failed) || (!known && failed) {
+			changed = true
+		}
+		state[key] = failed
+	}
+
+	for _, p := range run.Projects {
+		setState(p.Key(), p.Failed())
+	}
+	// server wide steps like s3 upload
+	for _, s := range run.Servers {
+		setState(s.Name, s.Failed())
+	}
+
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return changed, err
+	}
+	if err = util.CreatePath(statePath, 0755, true); err != nil {
+		return changed, err
+	}
+
+	return changed, os.WriteFile(statePath, b, 0644)
+}
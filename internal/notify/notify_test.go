@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"errors"
+	"github.com/apudiu/server-backup/internal/report"
+	"path/filepath"
+	"testing"
+)
+
+// projectRun returns a run of project app, failed when @failed is true
+func projectRun(failed bool) *report.Run {
+	var err error
+	if failed {
+		err = errors.New("zip failed")
+	}
+
+	run := report.New()
+	run.NewProject("web-1", "app", "").Begin(report.StepZip).Done(0, err)
+	run.Finish()
+	return run
+}
+
+func TestUpdateState(t *testing.T) {
+	tests := []struct {
+		name    string
+		runs    []bool
+		changed bool
+	}{
+		{"first success", []bool{false}, false},
+		{"first failure", []bool{true}, true},
+		{"still succeeds", []bool{false, false}, false},
+		{"still fails", []bool{true, true}, false},
+		{"fails", []bool{false, true}, true},
+		{"recovers", []bool{true, false}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statePath := filepath.Join(t.TempDir(), "state", "notify.json")
+
+			var changed bool
+			for _, failed := range tt.runs {
+				var err error
+				if changed, err = updateState(statePath, projectRun(failed)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if changed != tt.changed {
+				t.Errorf("changed %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
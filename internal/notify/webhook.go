@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/report"
+	"io"
+	"net/http"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// jsonPayload is posted to webhooks of "json" format
+type jsonPayload struct {
+	Subject string      `json:"subject"`
+	Failed  bool        `json:"failed"`
+	Text    string      `json:"text"`
+	Run     *report.Run `json:"run"`
+}
+
+// slackPayload is compatible with slack incoming webhooks & most chat tools
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+func sendWebhook(w config.Webhook, run *report.Run, subject, body string) error {
+	var payload any
+	switch w.Format {
+	case "slack":
+		payload = slackPayload{Text: "*" + subject + "*\n" + body}
+	case "", "json":
+		payload = jsonPayload{Subject: subject, Failed: run.Failed(), Text: body, Run: run}
+	default:
+		return fmt.Errorf("unknown webhook format %q", w.Format)
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.Url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		resBody, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("unexpected response %s. %s", res.Status, bytes.TrimSpace(resBody))
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/report"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// request is a webhook request received by the test server
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookServer starts a server responding by @status & @body, requests it gets are sent to the returned channel
+func webhookServer(t *testing.T, status int, body string) (*httptest.Server, <-chan request) {
+	t.Helper()
+	ch := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		ch <- request{header: r.Header, body: b}
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, ch
+}
+
+// failedRun returns a run having a failed project
+func failedRun() *report.Run {
+	run := report.New()
+	run.NewProject("web-1", "app", "").Begin(report.StepZip).Done(0, errors.New("zip failed"))
+	run.Finish()
+	return run
+}
+
+func TestSendWebhookJson(t *testing.T) {
+	srv, ch := webhookServer(t, http.StatusNoContent, "")
+	run := failedRun()
+	w := config.Webhook{Url: srv.URL, Headers: map[string]string{"Authorization": "Bearer token"}}
+
+	if err := sendWebhook(w, run, "subject", "body"); err != nil {
+		t.Fatal(err)
+	}
+	req := <-ch
+
+	if ct := req.header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type %q, want application/json", ct)
+	}
+	if auth := req.header.Get("Authorization"); auth != "Bearer token" {
+		t.Errorf("Authorization %q, want custom header", auth)
+	}
+
+	var got jsonPayload
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Fatalf("invalid payload %s: %v", req.body, err)
+	}
+	if got.Subject != "subject" || got.Text != "body" || !got.Failed {
+		t.Errorf("got payload %s, want subject, body & failed", req.body)
+	}
+	if got.Run == nil || got.Run.Id != run.Id || len(got.Run.Projects) != 1 {
+		t.Errorf("got payload %s, want the run summary", req.body)
+	}
+}
+
+func TestSendWebhookSlack(t *testing.T) {
+	srv, ch := webhookServer(t, http.StatusOK, "ok")
+
+	if err := sendWebhook(config.Webhook{Url: srv.URL, Format: "slack"}, failedRun(), "subject", "body"); err != nil {
+		t.Fatal(err)
+	}
+	req := <-ch
+
+	var got map[string]any
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Fatalf("invalid payload %s: %v", req.body, err)
+	}
+	if len(got) != 1 || got["text"] != "*subject*\nbody" {
+		t.Errorf("got payload %s, want only text", req.body)
+	}
+}
+
+func TestSendWebhookFailsByStatus(t *testing.T) {
+	srv, _ := webhookServer(t, http.StatusForbidden, "invalid token\n")
+
+	err := sendWebhook(config.Webhook{Url: srv.URL}, failedRun(), "subject", "body")
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "invalid token") {
+		t.Errorf("got error %v, want status & response body", err)
+	}
+}
+
+func TestSendWebhookUnknownFormat(t *testing.T) {
+	srv, ch := webhookServer(t, http.StatusOK, "")
+
+	if err := sendWebhook(config.Webhook{Url: srv.URL, Format: "xml"}, failedRun(), "subject", "body"); err == nil {
+		t.Error("unknown format is accepted")
+	}
+	select {
+	case <-ch:
+		t.Error("request is sent in unknown format")
+	default:
+	}
+}
@@ -0,0 +1,64 @@
+package remotebackup
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// probeObjectKey is prefix of the object written & deleted by CanWrite
+const probeObjectKey = ".server-backup-probe"
+
+// Credentials resolves credentials of the s3 user profile, returns where those were found, the access key id
+// (masked, only last 4 chars shown) & the region
+func (ud *UlDl) Credentials() (source, accessKeyId, region string, err error) {
+	opts := ud.client.Options()
+	if opts.Credentials == nil {
+		return "", "", opts.Region, errors.New("no credentials provider")
+	}
+
+	creds, err := opts.Credentials.Retrieve(context.TODO())
+	if err != nil {
+		return "", "", opts.Region, err
+	}
+
+	id := creds.AccessKeyID
+	if len(id) > 4 {
+		id = strings.Repeat("*", len(id)-4) + id[len(id)-4:]
+	}
+	return creds.Source, id, opts.Region, nil
+}
+
+// CanList checks objects of the bucket can be listed
+func (ud *UlDl) CanList() error {
+	_, err := ud.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
+		Bucket:  aws.String(ud.bucket),
+		MaxKeys: aws.Int32(1),
+	})
+	return err
+}
+
+// CanWrite checks objects can be uploaded to & deleted from the bucket, by writing & deleting an empty probe object.
+// Its key is unique, so no existing object is overwritten
+func (ud *UlDl) CanWrite() error {
+	key := probeObjectKey + "-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	_, err := ud.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(ud.bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(""),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = ud.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: aws.String(ud.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
@@ -2,9 +2,12 @@ package remotebackup
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/manifest"
 	"github.com/apudiu/server-backup/internal/util"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -17,8 +20,12 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// MetaSha256 is object metadata holding SHA-256 of a backup file, as listed in its manifest
+const MetaSha256 = "sha256"
+
 type UlDl struct {
 	client            *s3.Client
 	bucket, localDir  string
@@ -54,7 +61,8 @@ func (ud *UlDl) BucketExists() (bool, error) {
 	return exists, err
 }
 
-// DeleteObjects deletes a list of objects from a bucket.
+// DeleteObjects deletes a list of objects from a bucket. Objects the bucket failed to delete are returned as error,
+// along with the deleted ones
 func (ud *UlDl) DeleteObjects(objectKeys []string) (error, []types.DeletedObject) {
 	var objectIds []types.ObjectIdentifier
 	for _, key := range objectKeys {
@@ -68,24 +76,81 @@ func (ud *UlDl) DeleteObjects(objectKeys []string) (error, []types.DeletedObject
 		ud.logger.AddHeader(
 			util.ServerFailLogf("Couldn't delete objects from bucket %s. Here's why: %s", ud.bucket, err.Error()),
 		)
+		return err, nil
+	}
+
+	// request succeeds even when some objects aren't deleted, those are listed as errors
+	if len(output.Errors) > 0 {
+		msgs := make([]string, 0, len(output.Errors))
+		for _, e := range output.Errors {
+			msgs = append(msgs, aws.ToString(e.Key)+": "+aws.ToString(e.Code)+" "+aws.ToString(e.Message))
+		}
+		err = util.ErrWithPrefix(
+			fmt.Sprintf("%d of %d objects not deleted from bucket %s", len(output.Errors), len(objectKeys), ud.bucket),
+			errors.New(strings.Join(msgs, "; ")),
+		)
+		ud.logger.AddHeader(util.ServerFailLogf("%s", err.Error()))
+		return err, output.Deleted
 	}
-	return err, output.Deleted
+	return nil, output.Deleted
+}
+
+// DeleteDir deletes all objects of local backup dir @localDir from the bucket & returns number of deleted objects
+func (ud *UlDl) DeleteDir(localDir string) (int, error) {
+	objects, err := ud.ListObjectsWithPrefix(ObjectKey(localDir) + util.DS)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+
+	// s3 accepts up to 1000 keys per request
+	for start := 0; start < len(objects); start += 1000 {
+		end := min(start+1000, len(objects))
+
+		keys := make([]string, 0, end-start)
+		for _, o := range objects[start:end] {
+			keys = append(keys, *o.Key)
+		}
+
+		err, out := ud.DeleteObjects(keys)
+		deleted += len(out)
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
 }
 
 // ListObjects lists the objects in bucket.
 func (ud *UlDl) ListObjects() ([]types.Object, error) {
-	result, err := ud.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
+	return ud.ListObjectsWithPrefix("")
+}
+
+// ListObjectsWithPrefix lists the objects in bucket which keys start with @prefix.
+// All pages are fetched, so this is not limited to 1000 objects
+func (ud *UlDl) ListObjectsWithPrefix(prefix string) ([]types.Object, error) {
+	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(ud.bucket),
-	})
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+
 	var contents []types.Object
-	if err != nil {
-		ud.logger.AddHeader(
-			util.ServerFailLogf("Couldn't list objects in bucket %s. Here's why: %s", ud.bucket, err.Error()),
-		)
-	} else {
-		contents = result.Contents
+	paginator := s3.NewListObjectsV2Paginator(ud.client, input)
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			ud.logger.AddHeader(
+				util.ServerFailLogf("Couldn't list objects in bucket %s. Here's why: %s", ud.bucket, err.Error()),
+			)
+			return nil, err
+		}
+		contents = append(contents, result.Contents...)
 	}
-	return contents, err
+	return contents, nil
 }
 
 // CopyToFolder copies an object in a bucket to a sub folder in the same bucket.
@@ -108,14 +173,18 @@ func (ud *UlDl) CopyToFolder(objectKey string, folderName string) error {
 
 // UploadObject uses an upload manager to upload data to an object in a bucket.
 // The upload manager breaks large data into parts and uploads the parts concurrently.
-func (ud *UlDl) UploadObject(objectKey string, file io.Reader) (uploadResult *manager.UploadOutput, err error) {
+// @metadata (optional) is stored with the object
+func (ud *UlDl) UploadObject(
+	objectKey string, file io.Reader, metadata map[string]string,
+) (uploadResult *manager.UploadOutput, err error) {
 	uploader := manager.NewUploader(ud.client, func(u *manager.Uploader) {
 		u.PartSize = ud.transferChunkSize
 	})
 	uploadResult, err = uploader.Upload(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(ud.bucket),
-		Key:    aws.String(objectKey),
-		Body:   file,
+		Bucket:   aws.String(ud.bucket),
+		Key:      aws.String(objectKey),
+		Body:     file,
+		Metadata: metadata,
 	})
 	if err != nil {
 		ud.logger.AddHeader(
@@ -149,10 +218,9 @@ func (ud *UlDl) DownloadObject(objectKey string) ([]byte, error) {
 	return buffer.Bytes(), err
 }
 
-// DownloadToFile uses a download manager to download an object from a bucket to a file
-func (ud *UlDl) DownloadToFile(objectKey string, targetDirectory string) error {
+// DownloadToFile uses a download manager to download an object from a bucket to local @file
+func (ud *UlDl) DownloadToFile(objectKey string, file string) error {
 	// Create the directories in the path
-	file := filepath.Join(targetDirectory, objectKey)
 	if err := os.MkdirAll(filepath.Dir(file), 0775); err != nil {
 		return err
 	}
@@ -180,16 +248,163 @@ func (ud *UlDl) DownloadToFile(objectKey string, targetDirectory string) error {
 				ud.bucket, objectKey, err.Error(),
 			),
 		)
+		// don't leave a partial file, it would be taken as the backup
+		fd.Close()
+		_ = os.Remove(file)
 	}
 	return err
 }
 
-// UploadChangedOrNew uploads changed or newly added files to cloud from local backup dir
-func (ud *UlDl) UploadChangedOrNew() error {
+// UploadChangedOrNew uploads changed or newly added files to cloud from local backup dir.
+// When @subDirs (inside local backup dir) are given only those are considered
+func (ud *UlDl) UploadChangedOrNew(subDirs ...string) (uploaded []string, uploadedBytes int64, err error) {
+	fileList, err := ud.ChangedOrNew(subDirs...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var errs []error
+
+	// perform upload
+	for _, list := range fileList {
+		// using fn here for closing the file immediately after we're done with it
+		// else no file will be closed before all files are done
+		func(fp string) {
+			f, e := os.OpenFile(fp, os.O_RDONLY, 0644)
+			if e != nil {
+				ud.logger.AddHeader(
+					util.ServerFailLogf("%s error %s", fp, e.Error()),
+				)
+				errs = append(errs, e)
+				return
+			}
+			defer f.Close()
+
+			ud.logger.AddHeader(
+				util.ServerLogf("Uploading: %s", fp),
+			)
+			_, upErr := ud.UploadObject(ObjectKey(fp), f, manifestMetadata(fp))
+			if upErr != nil {
+				ud.logger.AddHeader(
+					util.ServerFailLogf("Upload err: %s", fp),
+				)
+				errs = append(errs, util.ErrWithPrefix("Upload err: "+fp, upErr))
+				return
+			}
+
+			uploaded = append(uploaded, fp)
+			if info, statErr := f.Stat(); statErr == nil {
+				uploadedBytes += info.Size()
+			}
+		}(list)
+	}
+
+	ud.logger.AddHeader(
+		util.ServerLogf("Uploaded %d files in s3", len(uploaded)),
+	)
+
+	return uploaded, uploadedBytes, errors.Join(errs...)
+}
+
+// manifestMetadata returns object metadata of backup file at @localPath, its SHA-256 when listed in the
+// manifest of its dir, so s3 copies can be checked against it later
+func manifestMetadata(localPath string) map[string]string {
+	m, err := manifest.Load(filepath.Dir(localPath))
+	if err != nil {
+		return nil
+	}
+
+	a, ok := m.Artifact(filepath.Base(localPath))
+	if !ok {
+		return nil
+	}
+	return map[string]string{MetaSha256: a.Sha256}
+}
+
+// ObjectSha256 returns SHA-256 stored in metadata of the object, empty when it was uploaded without it
+func (ud *UlDl) ObjectSha256(objectKey string) (string, error) {
+	out, err := ud.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(ud.bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return "", err
+	}
+	return out.Metadata[MetaSha256], nil
+}
+
+// LocalETag computes the ETag s3 reports for @localPath when uploaded by UploadObject.
+// Files bigger than transfer chunk size are uploaded in parts, their ETag is md5 of parts md5 with parts count
+func (ud *UlDl) LocalETag(localPath string) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var partSums []byte
+	parts := 0
+	for {
+		h := md5.New()
+		n, cpErr := io.CopyN(h, f, ud.transferChunkSize)
+		if cpErr != nil && cpErr != io.EOF {
+			return "", cpErr
+		}
+		if n == 0 && parts > 0 {
+			break
+		}
+
+		partSums = append(partSums, h.Sum(nil)...)
+		parts++
+
+		if cpErr == io.EOF {
+			break
+		}
+	}
+
+	if parts == 1 {
+		return hex.EncodeToString(partSums), nil
+	}
+
+	sum := md5.Sum(partSums)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), parts), nil
+}
+
+// VerifyLocalCopy compares a local backup file against its bucket object by size & ETag
+func (ud *UlDl) VerifyLocalCopy(localPath string, remoteSize int64, remoteETag string) error {
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+
+	if fi.Size() != remoteSize {
+		return fmt.Errorf("size mismatch, local %d bytes, s3 %d bytes", fi.Size(), remoteSize)
+	}
+
+	remoteETag = strings.Trim(remoteETag, `"`)
+	if remoteETag == "" {
+		return nil
+	}
+
+	localETag, err := ud.LocalETag(localPath)
+	if err != nil {
+		return err
+	}
+
+	if localETag != remoteETag {
+		return fmt.Errorf("checksum mismatch, local ETag %s, s3 ETag %s", localETag, remoteETag)
+	}
+
+	return nil
+}
+
+// ChangedOrNew returns local backup files which are missing in cloud or differ in size.
+// When @subDirs (inside local backup dir) are given only those are considered
+func (ud *UlDl) ChangedOrNew(subDirs ...string) ([]string, error) {
 	// get remote contents
 	remoteContents, remoteErr := ud.ListObjects()
 	if remoteErr != nil {
-		return remoteErr
+		return nil, remoteErr
 	}
 
 	// make remote contents map
@@ -207,11 +422,19 @@ func (ud *UlDl) UploadChangedOrNew() error {
 	// for upload
 	var fileList []string
 
-	// traverse local backup dir
-	walkEr := filepath.WalkDir(ud.localDir, func(path string, d fs.DirEntry, err error) error {
-		if d.IsDir() {
+	walkDirs := subDirs
+	if len(walkDirs) == 0 {
+		walkDirs = []string{ud.localDir}
+	}
+
+	walkFn := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
 			return err
 		}
+		// incomplete copies are left by interrupted downloads, those are resumed or replaced by next run
+		if d.IsDir() || util.IsPartFile(d.Name()) {
+			return nil
+		}
 
 		fi, err := d.Info()
 		if err != nil {
@@ -219,7 +442,7 @@ func (ud *UlDl) UploadChangedOrNew() error {
 		}
 
 		// check if this file exist in remote
-		rfSize, found := rcMap[path]
+		rfSize, found := rcMap[ObjectKey(path)]
 
 		// when missing in remote, add to upload list
 		if !found {
@@ -237,43 +460,29 @@ func (ud *UlDl) UploadChangedOrNew() error {
 		}
 
 		return err
-	})
-
-	if walkEr != nil {
-		return walkEr
 	}
 
-	// perform upload
-	for _, list := range fileList {
-		// using fn here for closing the file immediately after we're done with it
-		// else no file will be closed before all files are done
-		func(fp string) {
-			f, e := os.OpenFile(fp, os.O_RDONLY, 0644)
-			if e != nil {
-				ud.logger.AddHeader(
-					util.ServerFailLogf("%s error %s", fp, e.Error()),
-				)
-				return
-			}
-			defer f.Close()
-
-			ud.logger.AddHeader(
-				util.ServerLogf("Uploading: %s", fp),
-			)
-			_, upErr := ud.UploadObject(fp, f)
-			if upErr != nil {
-				ud.logger.AddHeader(
-					util.ServerFailLogf("Upload err: %s", fp),
-				)
-			}
-		}(list)
+	// traverse local backup dir
+	for _, dir := range walkDirs {
+		walkEr := filepath.WalkDir(dir, walkFn)
+		if walkEr != nil {
+			return nil, walkEr
+		}
 	}
 
-	ud.logger.AddHeader(
-		util.ServerLogf("Uploaded %d files in s3", len(fileList)),
-	)
+	return fileList, nil
+}
 
-	return nil
+// ObjectKey returns the bucket key of a local backup file. Keys are the local paths as uploads walk those,
+// cleaned by filepath.WalkDir (like backups/<server>/... for ./backups), so this must not change or
+// existing objects would not be found
+func ObjectKey(localPath string) string {
+	return filepath.Clean(localPath)
+}
+
+// DownloadBackupFile downloads a backup file from the bucket to its local path
+func (ud *UlDl) DownloadBackupFile(localPath string) error {
+	return ud.DownloadToFile(ObjectKey(localPath), localPath)
 }
 
 func New(
@@ -0,0 +1,120 @@
+package remotebackup
+
+import (
+	"fmt"
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// uploads walk local dirs & use walked paths as keys, ObjectKey must give the same key for lookups
+func TestObjectKeyMatchesWalkedPath(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	file := filepath.Join("backups", "web-1", "app", "2024-01-02", "2024-01-02_app.zip")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	roots := []string{
+		"./backups",
+		"./backups/",
+		"backups//web-1/",
+		"./backups/./web-1",
+		filepath.Join(dir, "backups"),
+	}
+	for _, root := range roots {
+		var walked []string
+		_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				walked = append(walked, p)
+			}
+			return nil
+		})
+		if len(walked) != 1 {
+			t.Fatalf("walking %q found %v", root, walked)
+		}
+
+		// lookups build local paths from config, unclean like root + DS + rest
+		rel, _ := filepath.Rel(filepath.Clean(root), walked[0])
+		lookup := root + string(filepath.Separator) + rel
+		if got := ObjectKey(lookup); got != walked[0] {
+			t.Errorf("ObjectKey(%q) = %q, uploaded key is %q", lookup, got, walked[0])
+		}
+	}
+}
+
+// s3Server starts a fake bucket listing @keys, deletion of @failKey fails while other deletions succeed
+func s3Server(t *testing.T, keys []string, failKey string) *UlDl {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+
+		if r.Method == http.MethodPost && r.URL.Query().Has("delete") {
+			_, _ = fmt.Fprint(w, `<DeleteResult>`)
+			for _, k := range keys {
+				if k == failKey {
+					_, _ = fmt.Fprintf(w, `<Error><Key>%s</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`, k)
+				} else {
+					_, _ = fmt.Fprintf(w, `<Deleted><Key>%s</Key></Deleted>`, k)
+				}
+			}
+			_, _ = fmt.Fprint(w, `</DeleteResult>`)
+			return
+		}
+
+		_, _ = fmt.Fprint(w, `<ListBucketResult><Name>bucket</Name><IsTruncated>false</IsTruncated>`)
+		for _, k := range keys {
+			_, _ = fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>1</Size></Contents>`, k)
+		}
+		_, _ = fmt.Fprint(w, `</ListBucketResult>`)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := s3.New(s3.Options{
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	return &UlDl{client: client, bucket: "bucket", localDir: "backups", logger: logger.New()}
+}
+
+func TestDeleteDir(t *testing.T) {
+	keys := []string{"backups/web-1/app/2024-01-02/a.zip", "backups/web-1/app/2024-01-02/b.sql.gz"}
+
+	n, err := s3Server(t, keys, "").DeleteDir("backups/web-1/app/2024-01-02")
+	if err != nil || n != 2 {
+		t.Errorf("got %d deleted, error %v, want 2 deleted", n, err)
+	}
+}
+
+func TestDeleteDirFailsByObjectErrors(t *testing.T) {
+	keys := []string{"backups/web-1/app/2024-01-02/a.zip", "backups/web-1/app/2024-01-02/b.sql.gz"}
+
+	n, err := s3Server(t, keys, keys[1]).DeleteDir("backups/web-1/app/2024-01-02")
+	if err == nil || !strings.Contains(err.Error(), keys[1]) || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("got error %v, want error of %s", err, keys[1])
+	}
+	if n != 1 {
+		t.Errorf("got %d deleted, want 1", n)
+	}
+}
@@ -0,0 +1,283 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// steps of a backup
+const (
+	StepConnect   = "connect"
+	StepPreflight = "preflight"
+	StepPrepare   = "prepare"
+	StepZip       = "zip"
+	StepCopy      = "copy"
+	StepDbDump    = "db dump"
+	StepDbCopy    = "db copy"
+	StepManifest  = "manifest"
+	StepVerify    = "verify"
+	StepRetention = "retention"
+	StepUpload    = "upload"
+)
+
+const (
+	StatusSuccess = "success"
+	StatusSkipped = "skipped"
+	StatusFailure = "failure"
+	// StatusPartial is status of a run in which some of the projects or steps failed
+	StatusPartial = "partial"
+)
+
+// Step is outcome of a single backup step
+type Step struct {
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	Start      time.Time `json:"start"`
+	DurationMs int64     `json:"durationMs"`
+	// Bytes processed by the step, like copied, uploaded or freed
+	Bytes int64  `json:"bytes,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Note explains a skipped step
+	Note string `json:"note,omitempty"`
+	// Retries made after failed attempts
+	Retries int `json:"retries,omitempty"`
+	// RetryErrors are errors of the retried attempts
+	RetryErrors []string `json:"retryErrors,omitempty"`
+	// Reconnects made for retrying after the connection was lost
+	Reconnects int `json:"reconnects,omitempty"`
+}
+
+// Done records end of the step with processed @bytes & returns @err, nil @err means success
+func (s *Step) Done(bytes int64, err error) error {
+	s.DurationMs = time.Since(s.Start).Milliseconds()
+	s.Bytes = bytes
+	s.Status = StatusSuccess
+	if err != nil {
+		s.Status = StatusFailure
+		s.Error = err.Error()
+	}
+	return err
+}
+
+// Skip records the step as not run for the reason @note
+func (s *Step) Skip(note string) {
+	s.DurationMs = time.Since(s.Start).Milliseconds()
+	s.Status = StatusSkipped
+	s.Note = note
+}
+
+// steps holds steps of a server or project, safe for concurrent use
+type steps struct {
+	mu    sync.Mutex
+	Steps []*Step `json:"steps"`
+}
+
+// Begin starts a step named @name, it must be ended by Done or Skip
+func (sl *steps) Begin(name string) *Step {
+	s := &Step{Name: name, Start: time.Now()}
+
+	sl.mu.Lock()
+	sl.Steps = append(sl.Steps, s)
+	sl.mu.Unlock()
+
+	return s
+}
+
+// Errors returns errors of failed steps prefixed by step name
+func (sl *steps) Errors() []string {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	var errs []string
+	for _, s := range sl.Steps {
+		if s.Status == StatusFailure {
+			errs = append(errs, s.Name+": "+s.Error)
+		}
+	}
+	return errs
+}
+
+func (sl *steps) Failed() bool {
+	return len(sl.Errors()) > 0
+}
+
+// File is an artifact taken or uploaded in the run
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+// Deletion is a backup dir deleted by retention from local disk or s3
+type Deletion struct {
+	Path     string `json:"path"`
+	Location string `json:"location"`
+}
+
+// Server holds steps done for the whole server, like connection & s3 upload
+type Server struct {
+	Name     string `json:"name"`
+	Uploaded []File `json:"uploaded,omitempty"`
+	steps
+}
+
+func (s *Server) AddUploaded(f File) {
+	s.mu.Lock()
+	s.Uploaded = append(s.Uploaded, f)
+	s.mu.Unlock()
+}
+
+// Project holds steps of a project backup
+type Project struct {
+	Server string `json:"server"`
+	Path   string `json:"path"`
+	// Dir is the dated backup dir
+	Dir       string     `json:"dir"`
+	Artifacts []File     `json:"artifacts,omitempty"`
+	Deleted   []Deletion `json:"deleted,omitempty"`
+	steps
+}
+
+func (p *Project) AddArtifact(f File) {
+	p.mu.Lock()
+	p.Artifacts = append(p.Artifacts, f)
+	p.mu.Unlock()
+}
+
+func (p *Project) AddDeleted(d Deletion) {
+	p.mu.Lock()
+	p.Deleted = append(p.Deleted, d)
+	p.mu.Unlock()
+}
+
+// Key identifies the project among all servers
+func (p *Project) Key() string {
+	return p.Server + "/" + p.Path
+}
+
+// Run is outcome of a backup run, safe for concurrent use while running
+type Run struct {
+	mu sync.Mutex
+	// Id identifies the run in the catalog
+	Id       string     `json:"id"`
+	Start    time.Time  `json:"start"`
+	End      time.Time  `json:"end"`
+	Status   string     `json:"status"`
+	Servers  []*Server  `json:"servers"`
+	Projects []*Project `json:"projects"`
+}
+
+func (r *Run) NewServer(name string) *Server {
+	s := &Server{Name: name}
+
+	r.mu.Lock()
+	r.Servers = append(r.Servers, s)
+	r.mu.Unlock()
+
+	return s
+}
+
+func (r *Run) NewProject(server, path, dir string) *Project {
+	p := &Project{Server: server, Path: path, Dir: dir}
+
+	r.mu.Lock()
+	r.Projects = append(r.Projects, p)
+	r.mu.Unlock()
+
+	return p
+}
+
+// Finish marks end of the run & sets its status
+func (r *Run) Finish() {
+	status := StatusSuccess
+	failed := r.FailedProjects()
+
+	switch {
+	case len(r.Projects) == 0 || failed == len(r.Projects):
+		if failed > 0 || r.serversFailed() {
+			status = StatusFailure
+		}
+	case failed > 0 || r.serversFailed():
+		status = StatusPartial
+	}
+
+	r.mu.Lock()
+	r.End = time.Now()
+	r.Status = status
+	r.mu.Unlock()
+}
+
+// FailedProjects returns number of projects having any failed step
+func (r *Run) FailedProjects() int {
+	failed := 0
+	for _, p := range r.Projects {
+		if p.Failed() {
+			failed++
+		}
+	}
+	return failed
+}
+
+func (r *Run) serversFailed() bool {
+	for _, s := range r.Servers {
+		if s.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Failed reports whether any project or server step failed
+func (r *Run) Failed() bool {
+	return r.FailedProjects() > 0 || r.serversFailed()
+}
+
+// WriteSummary writes the run as JSON in @path, replacing previous summary atomically
+func (r *Run) WriteSummary(path string) error {
+	r.mu.Lock()
+	b, err := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, strings.TrimSuffix(filepath.Base(path), ".json")+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// runSeq makes ids of runs started in the same second by the same process unique, like daemon jobs
+var runSeq atomic.Int64
+
+func New() *Run {
+	start := time.Now()
+	id := fmt.Sprintf("%s-%d-%d", start.Format("20060102-150405"), os.Getpid(), runSeq.Add(1))
+	return &Run{Id: id, Start: start}
+}
@@ -0,0 +1,245 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/apudiu/server-backup/internal/util"
+	"github.com/robfig/cron/v3"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// Job is a unit of work run by the scheduler
+type Job struct {
+	// Key identifies the job, it must be stable between restarts as last runs are stored by it
+	Key string
+	// Group of jobs never run concurrently, a job due while another of its group
+	// is running waits for it to finish
+	Group    string
+	Schedule cron.Schedule
+	// Jitter is max random delay added to each run
+	Jitter time.Duration
+	// CatchUp runs the job right after start when a run was missed while the scheduler was down
+	CatchUp bool
+	Run     func()
+}
+
+type entry struct {
+	job  Job
+	next time.Time
+}
+
+// group runs its queued entries one by one
+type group struct {
+	mu      sync.Mutex
+	queue   []*entry
+	running bool
+}
+
+type Scheduler struct {
+	entries   []*entry
+	groups    map[string]*group
+	statePath string
+	// state holds last run start time by job key
+	state   map[string]time.Time
+	stateMu sync.Mutex
+	logf    func(format string, a ...any)
+	wg      sync.WaitGroup
+}
+
+// ParseSchedule parses standard 5 field cron expression or descriptor like "@hourly", "@every 2h"
+func ParseSchedule(expr string) (cron.Schedule, error) {
+	return cron.ParseStandard(expr)
+}
+
+// RunsMoreThanDaily reports whether @schedule runs more than once on a day, within a week from @from
+func RunsMoreThanDaily(schedule cron.Schedule, from time.Time) bool {
+	end := from.AddDate(0, 0, 7)
+	prev := schedule.Next(from)
+	for !prev.IsZero() && prev.Before(end) {
+		next := schedule.Next(prev)
+		if next.Format(time.DateOnly) == prev.Format(time.DateOnly) {
+			return true
+		}
+		prev = next
+	}
+	return false
+}
+
+// Add adds a job, must be called before Run
+func (s *Scheduler) Add(j Job) {
+	s.entries = append(s.entries, &entry{job: j})
+	if s.groups[j.Group] == nil {
+		s.groups[j.Group] = &group{}
+	}
+}
+
+// Run schedules jobs till @ctx is done, then waits for running jobs to finish
+func (s *Scheduler) Run(ctx context.Context) error {
+	if len(s.entries) == 0 {
+		return errors.New("no jobs to schedule")
+	}
+
+	s.loadState()
+
+	now := time.Now()
+	for _, e := range s.entries {
+		last, ran := s.lastRun(e.job.Key)
+		if ran && e.job.CatchUp && !e.job.Schedule.Next(last).After(now) {
+			s.logf("Catching up %s, missed run of %s", e.job.Key, e.job.Schedule.Next(last).Format(time.DateTime))
+			s.dispatch(e)
+		}
+
+		e.next = s.nextRun(e, now)
+		s.logf("Scheduled %s at %s", e.job.Key, e.next.Format(time.DateTime))
+	}
+
+	for {
+		timer := time.NewTimer(time.Until(s.earliest()))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.logf("Stopping, waiting for running jobs to finish")
+			s.wg.Wait()
+			return nil
+
+		case <-timer.C:
+			now = time.Now()
+			for _, e := range s.entries {
+				if e.next.After(now) {
+					continue
+				}
+
+				s.dispatch(e)
+				e.next = s.nextRun(e, now)
+				s.logf("Scheduled %s at %s", e.job.Key, e.next.Format(time.DateTime))
+			}
+		}
+	}
+}
+
+func (s *Scheduler) nextRun(e *entry, after time.Time) time.Time {
+	next := e.job.Schedule.Next(after)
+	if e.job.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(e.job.Jitter))))
+	}
+	return next
+}
+
+func (s *Scheduler) earliest() time.Time {
+	earliest := s.entries[0].next
+	for _, e := range s.entries[1:] {
+		if e.next.Before(earliest) {
+			earliest = e.next
+		}
+	}
+	return earliest
+}
+
+// dispatch queues the entry in its group & starts group worker if idle.
+// An entry already waiting in the queue is not queued again
+func (s *Scheduler) dispatch(e *entry) {
+	g := s.groups[e.job.Group]
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, queued := range g.queue {
+		if queued == e {
+			s.logf("Skipping %s, previous run is still waiting", e.job.Key)
+			return
+		}
+	}
+
+	if g.running {
+		s.logf("Queued %s, waiting for running job of %s", e.job.Key, e.job.Group)
+	}
+	g.queue = append(g.queue, e)
+
+	if !g.running {
+		g.running = true
+		s.wg.Add(1)
+		go s.work(g)
+	}
+}
+
+// work runs queued entries of the group one by one till the queue is empty
+func (s *Scheduler) work(g *group) {
+	defer s.wg.Done()
+
+	for {
+		g.mu.Lock()
+		if len(g.queue) == 0 {
+			g.running = false
+			g.mu.Unlock()
+			return
+		}
+		e := g.queue[0]
+		g.queue = g.queue[1:]
+		g.mu.Unlock()
+
+		start := time.Now()
+		s.setLastRun(e.job.Key, start)
+
+		s.logf("Running %s", e.job.Key)
+		e.job.Run()
+		s.logf("Finished %s in %s", e.job.Key, time.Since(start).Round(time.Second))
+	}
+}
+
+func (s *Scheduler) lastRun(key string) (time.Time, bool) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	t, ok := s.state[key]
+	return t, ok
+}
+
+// setLastRun records last run & persists the state, so missed runs can be caught up after restart
+func (s *Scheduler) setLastRun(key string, t time.Time) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	s.state[key] = t
+
+	b, err := json.MarshalIndent(s.state, "", "  ")
+	if err == nil {
+		err = util.CreatePath(s.statePath, 0755, true)
+	}
+	if err == nil {
+		tmp := s.statePath + ".tmp"
+		err = os.WriteFile(tmp, b, 0644)
+		if err == nil {
+			err = os.Rename(tmp, s.statePath)
+		}
+	}
+	if err != nil {
+		s.logf("Failed to save scheduler state. %s", err.Error())
+	}
+}
+
+func (s *Scheduler) loadState() {
+	b, err := os.ReadFile(s.statePath)
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(b, &s.state); err != nil {
+		s.logf("Ignoring invalid scheduler state %s. %s", s.statePath, err.Error())
+		s.state = map[string]time.Time{}
+	}
+}
+
+// New creates a scheduler which keeps last runs in @statePath & logs by @logf
+func New(statePath string, logf func(format string, a ...any)) *Scheduler {
+	return &Scheduler{
+		groups:    map[string]*group{},
+		statePath: statePath,
+		state:     map[string]time.Time{},
+		logf:      logf,
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/util"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"net"
+	"os"
+	"time"
+)
+
+// ErrNoAuthMethod is returned when no auth method of the login can be used, so the server isn't dialed
+var ErrNoAuthMethod = errors.New("no usable auth method")
+
+// KeySigner parses private key of @c, using password for encrypted keys. When the key has
+// an OpenSSH certificate, the returned signer presents the certificate
+func KeySigner(c config.SshLogin) (ssh.Signer, error) {
+	if c.Key == "" {
+		return nil, errors.New("privateKeyPath is not specified")
+	}
+
+	serverKey, err := os.ReadFile(c.Key)
+	if err != nil {
+		return nil, util.ErrWithPrefix("Server private key read failed", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(serverKey)
+	var missingPass *ssh.PassphraseMissingError
+	if errors.As(err, &missingPass) {
+		// encrypted key, parse it using the password
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(serverKey, []byte(c.Password))
+	}
+	if err != nil {
+		return nil, util.ErrWithPrefix("Server private key parsing failed", err)
+	}
+
+	certPath := c.CertificatePath()
+	if certPath == "" {
+		return signer, nil
+	}
+
+	cert, err := parseCertificate(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, util.ErrWithPrefix("Certificate "+certPath+" doesn't match the private key", err)
+	}
+	return certSigner, nil
+}
+
+// parseCertificate parses OpenSSH certificate at @path & checks it's not expired
+func parseCertificate(path string) (*ssh.Certificate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, util.ErrWithPrefix("Certificate read failed", err)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil, util.ErrWithPrefix("Certificate "+path+" parsing failed", err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New(path + " is a public key, not a certificate")
+	}
+
+	if cert.ValidBefore != ssh.CertTimeInfinity && time.Now().Unix() >= int64(cert.ValidBefore) {
+		return nil, fmt.Errorf(
+			"certificate %s expired at %s", path, time.Unix(int64(cert.ValidBefore), 0).Format(time.DateTime),
+		)
+	}
+	return cert, nil
+}
+
+// authMethods builds auth methods of @c in configured order. Keys of agent & key file are offered
+// in one publickey method, as each method is tried once. Methods which can't be used are left out &
+// reported by the error, returned closeFn releases agent connection
+func authMethods(c config.SshLogin) (methods []ssh.AuthMethod, closeFn func(), err error) {
+	var (
+		signers []func() ([]ssh.Signer, error)
+		errs    []error
+		agents  []net.Conn
+	)
+
+	closeFn = func() {
+		for _, a := range agents {
+			_ = a.Close()
+		}
+	}
+
+	addPublicKeys := func(source func() ([]ssh.Signer, error)) {
+		// first publickey source adds the method, at its position
+		if len(signers) == 0 {
+			methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				var all []ssh.Signer
+				for _, s := range signers {
+					if ss, sErr := s(); sErr == nil {
+						all = append(all, ss...)
+					}
+				}
+				return all, nil
+			}))
+		}
+		signers = append(signers, source)
+	}
+
+	for _, m := range c.Auth() {
+		switch m {
+		case config.AuthAgent:
+			sock := os.Getenv("SSH_AUTH_SOCK")
+			if sock == "" {
+				errs = append(errs, errors.New("agent auth: SSH_AUTH_SOCK is not set"))
+				continue
+			}
+
+			conn, dialErr := net.Dial("unix", sock)
+			if dialErr != nil {
+				errs = append(errs, util.ErrWithPrefix("agent auth: failed to connect to "+sock, dialErr))
+				continue
+			}
+			agents = append(agents, conn)
+			addPublicKeys(agent.NewClient(conn).Signers)
+
+		case config.AuthPublicKey:
+			signer, keyErr := KeySigner(c)
+			if keyErr != nil {
+				errs = append(errs, util.ErrWithPrefix("publickey auth", keyErr))
+				continue
+			}
+			addPublicKeys(func() ([]ssh.Signer, error) {
+				return []ssh.Signer{signer}, nil
+			})
+
+		case config.AuthPassword:
+			if c.Password == "" {
+				errs = append(errs, errors.New("password auth: password is not specified"))
+				continue
+			}
+			methods = append(methods,
+				ssh.Password(c.Password),
+				// servers with password auth disabled often still accept it by keyboard-interactive
+				ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+					answers := make([]string, len(questions))
+					for i := range answers {
+						answers[i] = c.Password
+					}
+					return answers, nil
+				}),
+			)
+
+		default:
+			errs = append(errs, errors.New("unknown auth method "+m))
+		}
+	}
+
+	if len(methods) == 0 {
+		errs = append(errs, ErrNoAuthMethod)
+	}
+	return methods, closeFn, errors.Join(errs...)
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/util"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrHostKeyUnknown = errors.New("host key unknown")
+	ErrHostKeyChanged = errors.New("host key changed")
+)
+
+// knownHostsMu serializes recording keys, servers are connected concurrently
+var knownHostsMu sync.Mutex
+
+// HostKeyCallback returns callback verifying host key by pinned fingerprint of @hk or its known hosts file.
+// In tofu mode unknown keys are added to the known hosts file
+func HostKeyCallback(hk config.HostKey) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fp := ssh.FingerprintSHA256(key)
+
+		if hk.Fingerprint != "" {
+			if fp != hk.Fingerprint {
+				return fmt.Errorf(
+					"%w for %s: got %s %s, pinned %s. Update hostKey.fingerprint only if the key was rotated",
+					ErrHostKeyChanged, hostname, key.Type(), fp, hk.Fingerprint,
+				)
+			}
+			return nil
+		}
+
+		path := hk.KnownHostsPath()
+
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		err := checkKnownHost(path, hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		// only a different key of a known type is a changed key
+		for _, want := range keyErr.Want {
+			if want.Key.Type() == key.Type() {
+				return fmt.Errorf(
+					"%w for %s: got %s %s, known %s %s at %s:%d. Remove the old key only if it was rotated",
+					ErrHostKeyChanged, hostname, key.Type(), fp,
+					want.Key.Type(), ssh.FingerprintSHA256(want.Key), want.Filename, want.Line,
+				)
+			}
+		}
+		// HostKeyAlgorithms are limited to known types, so it's offered only when known keys aren't supported.
+		// It's not trusted in tofu mode, a known host must not be taken over by a new key
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf(
+				"%w for %s: got %s %s, only %s keys are known in %s. Add it by `ssh-keyscan` after checking the fingerprint",
+				ErrHostKeyUnknown, hostname, key.Type(), fp, strings.Join(keyTypes(keyErr.Want), ", "), path,
+			)
+		}
+
+		if !hk.TrustOnFirstUse() {
+			return fmt.Errorf(
+				"%w for %s: %s %s is not in %s. Add it by `ssh-keyscan` after checking the fingerprint or use hostKey.mode tofu",
+				ErrHostKeyUnknown, hostname, key.Type(), fp, path,
+			)
+		}
+
+		if err = addKnownHost(path, hostname, key); err != nil {
+			return err
+		}
+		log.Printf("Trusted new host key of %s: %s %s, recorded in %s", hostname, key.Type(), fp, path)
+		return nil
+	}
+}
+
+// KnownHostKeyAlgorithms returns host key algorithms of keys known for @hostname (dialed at @remote) by @hk, for
+// ssh.ClientConfig.HostKeyAlgorithms, so the server offers a key of a known type. Nil when no key is known or the key
+// is pinned by fingerprint, then any algorithm is accepted
+func KnownHostKeyAlgorithms(hk config.HostKey, hostname string, remote net.Addr) []string {
+	if hk.Fingerprint != "" {
+		return nil
+	}
+
+	knownHostsMu.Lock()
+	err := checkKnownHost(hk.KnownHostsPath(), hostname, remote, probeKey{})
+	knownHostsMu.Unlock()
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	for _, t := range keyTypes(keyErr.Want) {
+		// rsa keys sign by sha2 algorithms too
+		if t == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, t)
+	}
+	return algorithms
+}
+
+// keyTypes returns types of @keys sorted
+func keyTypes(keys []knownhosts.KnownKey) []string {
+	types := make([]string, 0, len(keys))
+	for _, k := range keys {
+		types = append(types, k.Key.Type())
+	}
+	slices.Sort(types)
+	return types
+}
+
+// probeKey matches no known key, checking it tells which keys are known for a host
+type probeKey struct{}
+
+func (probeKey) Type() string {
+	return "probe"
+}
+
+func (probeKey) Marshal() []byte {
+	return nil
+}
+
+func (probeKey) Verify([]byte, *ssh.Signature) error {
+	return errors.New("probe key can't verify")
+}
+
+// checkKnownHost checks @key against known hosts file at @path, missing file means no host is known
+func checkKnownHost(path, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if exists, _ := util.IsPathExist(path); !exists {
+		return &knownhosts.KeyError{}
+	}
+
+	cb, err := knownhosts.New(path)
+	if err != nil {
+		return util.ErrWithPrefix("Failed to read known hosts "+path, err)
+	}
+
+	return cb(hostname, remote, key)
+}
+
+// addKnownHost appends @key of @hostname to known hosts file at @path
+func addKnownHost(path, hostname string, key ssh.PublicKey) error {
+	err := util.CreatePath(path, 0700, true)
+	if err != nil {
+		return util.ErrWithPrefix("Failed to record host key in "+path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return util.ErrWithPrefix("Failed to record host key in "+path, err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err = f.WriteString(line + "\n"); err != nil {
+		return util.ErrWithPrefix("Failed to record host key in "+path, err)
+	}
+	return nil
+}
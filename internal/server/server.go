@@ -2,48 +2,245 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/shell"
 	"github.com/apudiu/server-backup/internal/util"
 	"github.com/bramvdbogaerde/go-scp"
 	"golang.org/x/crypto/ssh"
 	"io"
 	"net"
 	"os"
-	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// commandTimeouts holds max run time of commands per connection, set from server config on connect
+var commandTimeouts sync.Map
+
+// ErrAuthRejected is returned when the server rejects all auth methods tried
+var ErrAuthRejected = errors.New("ssh login rejected")
+
+// JumpHostError is returned when connecting to jump host at Address fails, Err tells why
+type JumpHostError struct {
+	Address string
+	Err     error
+}
+
+func (e *JumpHostError) Error() string {
+	return "Jump host " + e.Address + " connection failed - " + e.Err.Error()
+}
+
+func (e *JumpHostError) Unwrap() error {
+	return e.Err
+}
+
+// keepAliveMaxMissed is number of unanswered keepalive intervals after which connection is closed
+const keepAliveMaxMissed = 3
+
+// ConnectToServer connects to the server, through its jump hosts when configured. Connection is
+// kept alive & its commands are limited by timeouts of server config
 func ConnectToServer(c *config.ServerConfig) (conn *ssh.Client, err error) {
-	serverKey := util.ReadFromFile(c.Key)
-	signer, err := ssh.ParsePrivateKey(serverKey)
+	dialTimeout := c.Connection.Dial()
+
+	for _, j := range c.JumpHosts {
+		conn, err = connectHop(conn, j.Address(), j.Login(c.SshLogin), dialTimeout)
+		if err != nil {
+			// wrapped, so host key & auth errors of jump hosts are told apart like the server's
+			return nil, &JumpHostError{Address: j.Address(), Err: err}
+		}
+	}
+
+	conn, err = connectHop(conn, c.Address(), c.SshLogin, dialTimeout)
 	if err != nil {
+		return nil, err
+	}
+
+	if t := c.Connection.Command(); t > 0 {
+		commandTimeouts.Store(conn, t)
+	}
+	go keepAlive(conn, c.Connection.KeepAliveInterval())
+	go func() {
+		_ = conn.Wait()
+		commandTimeouts.Delete(conn)
+	}()
+	return conn, nil
+}
 
-		// todo: check for this err type
-		//if errors.Is(err, ssh.PassphraseMissingError{}) {}
+// Retryable reports whether connection error @err might be temporary. Host key & auth failures are not
+func Retryable(err error) bool {
+	return !errors.Is(err, ErrHostKeyChanged) && !errors.Is(err, ErrHostKeyUnknown) &&
+		!errors.Is(err, ErrAuthRejected) && !errors.Is(err, ErrNoAuthMethod)
+}
+
+// Alive reports whether @conn still answers requests
+func Alive(conn *ssh.Client) bool {
+	_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+	return err == nil
+}
 
-		// if failed try to parse key using the password
-		signer, err = ssh.ParsePrivateKeyWithPassphrase(serverKey, []byte(c.Password))
-		util.FailIfErr(err, "Server private key parsing failed")
+// connectHop connects to @addr, through @via when not nil. Connecting & logging in must be done within
+// @timeout. Closing returned client closes @via too
+func connectHop(via *ssh.Client, addr string, login config.SshLogin, timeout time.Duration) (*ssh.Client, error) {
+	auth, closeAuth, authErr := authMethods(login)
+	defer closeAuth()
+
+	closeVia := func() {
+		if via != nil {
+			_ = via.Close()
+		}
 	}
 
-	//hostKeyCallback, hostKeyErr := knownhosts.New("~/.ssh/known_hosts")
-	//failIfErr(hostKeyErr)
+	if len(auth) == 0 {
+		closeVia()
+		return nil, authErr
+	}
 
 	conf := &ssh.ClientConfig{
-		User: c.User,
-		//HostKeyCallback: hostKeyCallback,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-			ssh.Password(c.Password),
-		},
-		//Timeout: 15 * time.Second,
+		User:            login.User,
+		HostKeyCallback: HostKeyCallback(login.HostKey),
+		Auth:            auth,
+		Timeout:         timeout,
 	}
 
-	// connect to server
+	var (
+		nc  net.Conn
+		err error
+	)
+	if via == nil {
+		nc, err = net.DialTimeout("tcp", addr, timeout)
+	} else {
+		// tunnel through the jump host
+		nc, err = via.Dial("tcp", addr)
+	}
+	if err != nil {
+		closeVia()
+		return nil, util.ErrWithPrefix("Connection to "+addr+" failed", err)
+	}
+	// server is asked for a key of a known type, so a key of another type isn't taken as changed
+	conf.HostKeyAlgorithms = KnownHostKeyAlgorithms(login.HostKey, addr, nc.RemoteAddr())
 
-	hostWithPort := net.JoinHostPort(c.Ip.String(), strconv.Itoa(c.Port))
-	conn, err = ssh.Dial("tcp", hostWithPort, conf)
-	return
+	// handshake & auth can hang on unresponsive servers, tunneled conns don't support deadlines
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		_ = nc.Close()
+	})
+	sc, chans, reqs, err := ssh.NewClientConn(nc, addr, conf)
+	timer.Stop()
+	if err != nil {
+		_ = nc.Close()
+		closeVia()
+		if timedOut.Load() {
+			err = fmt.Errorf("ssh handshake with %s timed out after %s", addr, timeout)
+		} else if len(conf.HostKeyAlgorithms) > 0 && strings.Contains(err.Error(), "no common algorithm for host key") {
+			err = fmt.Errorf(
+				"%w for %s: it has no key of known types %s in %s. Add its key by `ssh-keyscan` after checking the fingerprint - %w",
+				ErrHostKeyUnknown, addr, strings.Join(conf.HostKeyAlgorithms, ", "), login.HostKey.KnownHostsPath(), err,
+			)
+		} else if strings.Contains(err.Error(), "unable to authenticate") {
+			// x/crypto/ssh doesn't export a type for auth failures
+			err = fmt.Errorf("%w - %w", ErrAuthRejected, err)
+		}
+		return nil, withAuthErr(err, authErr)
+	}
+
+	conn := ssh.NewClient(sc, chans, reqs)
+	if via != nil {
+		go func() {
+			_ = conn.Wait()
+			_ = via.Close()
+		}()
+	}
+	return conn, nil
+}
+
+// keepAlive sends keepalive requests to @conn every @interval, closes it when the server doesn't
+// answer for keepAliveMaxMissed intervals so blocked commands & transfers fail instead of hanging
+func keepAlive(conn *ssh.Client, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		_ = conn.Wait()
+		close(closed)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+
+		replied := make(chan error, 1)
+		go func() {
+			// any reply, even failure, means the server is alive
+			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+
+		select {
+		case <-closed:
+			return
+		case err := <-replied:
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+		case <-time.After(interval * keepAliveMaxMissed):
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// withAuthErr adds errors of auth methods left out to connection error @err, those might be why auth failed
+func withAuthErr(err, authErr error) error {
+	if err != nil && authErr != nil {
+		return errors.Join(err, authErr)
+	}
+	return err
+}
+
+// commandTimeout returns max run time of commands on @conn, zero means no limit
+func commandTimeout(conn *ssh.Client) time.Duration {
+	if t, ok := commandTimeouts.Load(conn); ok {
+		return t.(time.Duration)
+	}
+	return 0
+}
+
+// limitSession closes @session when command timeout of @conn passes, returned stop func cancels it
+// & turns error of a timed out command to a timeout error
+func limitSession(conn *ssh.Client, session *ssh.Session) (stop func(err error) error) {
+	timeout := commandTimeout(conn)
+	if timeout <= 0 {
+		return func(err error) error { return err }
+	}
+
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+	})
+
+	return func(err error) error {
+		timer.Stop()
+		if timedOut.Load() {
+			return fmt.Errorf("command timed out after %s", timeout)
+		}
+		return err
+	}
 }
 
 // ExecCmd executes cmd and returns the response
@@ -54,7 +251,9 @@ func ExecCmd(conn *ssh.Client, cmd string) (result []byte, err error) {
 	}
 	defer session.Close()
 
+	stop := limitSession(conn, session)
 	result, err = session.CombinedOutput(cmd)
+	err = stop(err)
 	return
 }
 
@@ -79,19 +278,49 @@ func ExecCmdLive(
 		}
 	}
 
-	wait = session.Wait
+	start, wait = limitedRun(conn, session, cmd)
+	closeFn = session.Close
+	return
+}
+
+// ExecCmdStream executes cmd writing its stdOut to @stdOut, like a zip streamed to a local file, and pipes
+// stdErr in @stdErr for live reading. @start, @wait & @closeFn are used like ExecCmdLive
+func ExecCmdStream(
+	conn *ssh.Client, cmd string, stdOut io.Writer, stdErr *io.Reader,
+) (start, wait, closeFn func() error, err error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return
+	}
+
+	session.Stdout = stdOut
+	*stdErr, err = session.StderrPipe()
+	if err != nil {
+		_ = session.Close()
+		return
+	}
+
+	start, wait = limitedRun(conn, session, cmd)
+	closeFn = session.Close
+	return
+}
+
+// limitedRun returns funcs starting @cmd in @session & waiting for it, limited by command timeout of @conn
+func limitedRun(conn *ssh.Client, session *ssh.Session, cmd string) (start, wait func() error) {
+	stop := func(err error) error { return err }
 	start = func() error {
+		stop = limitSession(conn, session)
 		return session.Start(cmd)
 	}
-	closeFn = session.Close
+	wait = func() error {
+		return stop(session.Wait())
+	}
 	return
 }
 
 // RemoteIsPathExist checks if remote path exists
 func RemoteIsPathExist(c *ssh.Client, p string) (bool, error) {
-	cmd := "ls " + p
-
-	_, err := ExecCmd(c, cmd)
+	_, err := ExecCmd(c, shell.Command("ls").Op("-d --").Path(p).String())
 	if err != nil {
 		return false, err
 	}
@@ -117,7 +346,7 @@ func GetFileFromServer(c *ssh.Client, sourcePath, destPath string) (success bool
 	// download the file
 
 	// open local file to write to it
-	df, err := os.OpenFile(destPath, os.O_RDWR|os.O_CREATE, 0644)
+	df, err := os.OpenFile(destPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		err = util.ErrWithPrefix("Dest file creation error on", err)
 		return
@@ -139,3 +368,35 @@ func GetFileFromServer(c *ssh.Client, sourcePath, destPath string) (success bool
 
 	return true, nil
 }
+
+func PutFileToServer(c *ssh.Client, sourcePath, destPath string) (success bool, err error) {
+	client, err := scp.NewClientBySSH(c)
+	if err != nil {
+		err = util.ErrWithPrefix("Failed to get server session", err)
+		return
+	}
+	defer client.Close()
+
+	// open local file to read from it
+	sf, err := os.Open(sourcePath)
+	if err != nil {
+		err = util.ErrWithPrefix("Source file open error on", err)
+		return
+	}
+	defer sf.Close()
+
+	err = client.CopyFromFile(context.Background(), *sf, destPath, "0644")
+	if err != nil {
+		err = util.ErrWithPrefix("File transfer failed for "+sourcePath, err)
+		return
+	}
+
+	// verify file
+	isExist, err := RemoteIsPathExist(c, destPath)
+	if err != nil || !isExist {
+		err = util.ErrWithPrefix("Uploaded file might not be usable ", err)
+		return
+	}
+
+	return true, nil
+}
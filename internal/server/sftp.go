@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"github.com/apudiu/server-backup/internal/util"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"time"
+)
+
+// progressInterval is min time between progress reports of a transfer
+const progressInterval = 10 * time.Second
+
+// Progress of a transfer
+type Progress struct {
+	// Done is bytes transferred, including resumed ones
+	Done int64
+	// Total is size of the file
+	Total int64
+	// Resumed is bytes of earlier attempt the download was resumed from
+	Resumed int64
+	// Rate is bytes per second of this attempt
+	Rate float64
+	// Eta is estimated time till done, zero when unknown
+	Eta time.Duration
+}
+
+// DownloadSftp downloads @sourcePath to @destPath by sftp. An interrupted download left in @destPath is resumed from
+// its current offset by next call when remote file didn't change since. Remote size & mtime the download was started
+// from are kept in <destPath>.meta to tell that, local clock isn't compared to remote.
+// @progress (optional) is called every progressInterval & when done. Downloaded size is verified by remote stat
+func DownloadSftp(c *ssh.Client, sourcePath, destPath string, progress func(p Progress)) error {
+	client, err := sftp.NewClient(c)
+	if err != nil {
+		return util.ErrWithPrefix("Failed to start sftp session", err)
+	}
+	defer client.Close()
+
+	rf, err := client.Open(sourcePath)
+	if err != nil {
+		return util.ErrWithPrefix("Failed to open remote file "+sourcePath, err)
+	}
+	defer rf.Close()
+
+	remote, err := rf.Stat()
+	if err != nil {
+		return util.ErrWithPrefix("Failed to stat remote file "+sourcePath, err)
+	}
+
+	meta := destPath + util.PartMetaSuffix
+	offset := resumeOffset(destPath, meta, remote)
+	if offset == 0 {
+		// written before the download, so a download without it is never resumed
+		if err = os.WriteFile(meta, []byte(partMeta(remote)), 0644); err != nil {
+			return util.ErrWithPrefix("Failed to write "+meta, err)
+		}
+	}
+
+	// a stale or bigger download is truncated, so no garbage is left after the new content
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	lf, err := os.OpenFile(destPath, flags, 0644)
+	if err != nil {
+		return util.ErrWithPrefix("Dest file creation error on", err)
+	}
+	defer lf.Close()
+
+	if _, err = rf.Seek(offset, io.SeekStart); err != nil {
+		return util.ErrWithPrefix("Failed to resume "+sourcePath, err)
+	}
+
+	start := time.Now()
+	pw := &progressWriter{w: lf, start: start, lastReport: start, offset: offset, total: remote.Size(), report: progress}
+	if _, err = io.Copy(pw, rf); err != nil {
+		return util.ErrWithPrefix("File transfer failed for "+sourcePath, err)
+	}
+	pw.reportProgress()
+
+	if err = lf.Close(); err != nil {
+		return util.ErrWithPrefix("Failed to write "+destPath, err)
+	}
+
+	// done, nothing to resume anymore
+	_ = os.Remove(meta)
+
+	size := util.PathSize(destPath)
+	if size != remote.Size() {
+		_ = os.Remove(destPath)
+		return fmt.Errorf("size of %s is %d bytes, remote file is %d bytes", destPath, size, remote.Size())
+	}
+	return nil
+}
+
+// resumeOffset returns size of earlier partial download at @path, when it can be resumed for @remote.
+// It can when remote size & mtime recorded in @meta when the download was started still match
+func resumeOffset(path, meta string, remote os.FileInfo) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+
+	recorded, err := os.ReadFile(meta)
+	if err != nil || string(recorded) != partMeta(remote) || fi.Size() > remote.Size() {
+		return 0
+	}
+	return fi.Size()
+}
+
+// partMeta returns content of download meta file of @remote, like "<size> <mtime unix>"
+func partMeta(remote os.FileInfo) string {
+	return fmt.Sprintf("%d %d\n", remote.Size(), remote.ModTime().Unix())
+}
+
+// progressWriter counts bytes written to w & reports progress periodically
+type progressWriter struct {
+	w          io.Writer
+	start      time.Time
+	lastReport time.Time
+	offset     int64
+	written    int64
+	total      int64
+	report     func(p Progress)
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.written += int64(n)
+
+	if time.Since(pw.lastReport) >= progressInterval {
+		pw.reportProgress()
+	}
+	return n, err
+}
+
+func (pw *progressWriter) reportProgress() {
+	if pw.report == nil {
+		return
+	}
+	pw.lastReport = time.Now()
+
+	p := Progress{Done: pw.offset + pw.written, Total: pw.total, Resumed: pw.offset}
+	if elapsed := time.Since(pw.start).Seconds(); elapsed > 0 {
+		p.Rate = float64(pw.written) / elapsed
+	}
+	if p.Rate > 0 && p.Total > p.Done {
+		p.Eta = time.Duration(float64(p.Total-p.Done) / p.Rate * float64(time.Second))
+	}
+	pw.report(p)
+}
@@ -0,0 +1,87 @@
+package shell
+
+import (
+	"regexp"
+	"strings"
+)
+
+// unsafeChar matches chars having special meaning in POSIX shells or in need of quoting
+var unsafeChar = regexp.MustCompile(`[^\w@%+=:,./-]`)
+
+// Quote returns @s quoted as a single word for POSIX shells, so it's passed as is to the command.
+// Words of safe chars only are kept unquoted for readability
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if !unsafeChar.MatchString(s) {
+		return s
+	}
+	// nothing is special inside single quotes, a single quote is closed, escaped & reopened
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// QuotePath is like Quote, but a leading ~/ is kept unquoted, so the path stays relative to home dir of the user.
+// A path starting with "-" gets "./" prefix, so it's never taken as an option, even by commands not knowing "--"
+func QuotePath(p string) string {
+	if strings.HasPrefix(p, "-") {
+		p = "./" + p
+	}
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		return "~/" + Quote(rest)
+	}
+	if p == "~" {
+		return p
+	}
+	return Quote(p)
+}
+
+// Cmd is a shell command line built from quoted words & operators
+type Cmd struct {
+	words []string
+}
+
+// Command returns command line running @name with @args, all quoted
+func Command(name string, args ...string) *Cmd {
+	return (&Cmd{}).Arg(name).Arg(args...)
+}
+
+// Op returns command line starting with @op, see Cmd.Op
+func Op(op string) *Cmd {
+	return (&Cmd{}).Op(op)
+}
+
+// Arg adds @args quoted
+func (c *Cmd) Arg(args ...string) *Cmd {
+	for _, a := range args {
+		c.words = append(c.words, Quote(a))
+	}
+	return c
+}
+
+// Path adds remote paths @paths quoted by QuotePath. Operands of commands knowing "--" are added after Op("--") too
+func (c *Cmd) Path(paths ...string) *Cmd {
+	for _, p := range paths {
+		c.words = append(c.words, QuotePath(p))
+	}
+	return c
+}
+
+// Op adds @op as is, like "&&", "|", "2>&1" or options like "-ry9". It must be a constant, never a value
+// from config or the server, those are added by Arg or Path
+func (c *Cmd) Op(op string) *Cmd {
+	c.words = append(c.words, op)
+	return c
+}
+
+// Then adds @next after @op, like c && next or c | next
+func (c *Cmd) Then(op string, next *Cmd) *Cmd {
+	c.words = append(c.words, op)
+	c.words = append(c.words, next.words...)
+	return c
+}
+
+// String returns the command line
+func (c *Cmd) String() string {
+	return strings.Join(c.words, " ")
+}
@@ -0,0 +1,110 @@
+package shell_test
+
+import (
+	"github.com/apudiu/server-backup/internal/shell"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// hostile values, each must reach the command as a single unchanged argument
+var hostile = []struct {
+	name, value string
+}{
+	{"empty", ""},
+	{"plain", "plain"},
+	{"space", "with space"},
+	{"single quote", "it's"},
+	{"only single quotes", "''"},
+	{"double quote", `say "hi"`},
+	{"dollar", "$HOME"},
+	{"command substitution", "$(touch pwned)"},
+	{"backtick", "`touch pwned`"},
+	{"newline", "a\nb"},
+	{"semicolon", "a; touch pwned"},
+	{"glob", "*"},
+	{"leading dash", "-rf"},
+	{"long option", "--result-file=pwned"},
+	{"backslash", `a\b`},
+	{"tilde", "~/x"},
+	{"bang", "!x"},
+}
+
+// sh runs @script by sh in @dir, fails @t on error. Skipped where sh is unavailable
+func sh(t *testing.T, dir, script string) string {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is unavailable")
+	}
+
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("sh -c %q failed: %v, output: %q", script, err, out)
+	}
+	return string(out)
+}
+
+func TestQuote(t *testing.T) {
+	for _, h := range hostile {
+		t.Run(h.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			out := sh(t, dir, `printf "%s\n" `+shell.Quote(h.value))
+			if out != h.value+"\n" {
+				t.Errorf("Quote(%q) = %s, sh printed %q", h.value, shell.Quote(h.value), out)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+				t.Errorf("Quote(%q) = %s ran injected command", h.value, shell.Quote(h.value))
+			}
+		})
+	}
+}
+
+func TestQuoteKeepsSafeWords(t *testing.T) {
+	for _, s := range []string{"zip", "-ry9", "/var/www/app", "a.b,c=d:e@f%g+h"} {
+		if q := shell.Quote(s); q != s {
+			t.Errorf("Quote(%q) = %q, want it unquoted", s, q)
+		}
+	}
+}
+
+func TestQuotePath(t *testing.T) {
+	home := t.TempDir()
+
+	tests := []struct {
+		path, want string
+	}{
+		{"~", home},
+		{"~/", home + "/"},
+		{"~/my app", home + "/my app"},
+		{"~/$(touch pwned)", home + "/$(touch pwned)"},
+		{"/srv/~/x", "/srv/~/x"},
+		{"~user/x", "~user/x"},
+		{"it's", "it's"},
+		{"-rf", "./-rf"},
+		{"--result-file=x", "./--result-file=x"},
+		{"-", "./-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			out := sh(t, home, `HOME=`+shell.Quote(home)+`; printf "%s\n" `+shell.QuotePath(tt.path))
+			if out != tt.want+"\n" {
+				t.Errorf("QuotePath(%q) = %s, sh printed %q, want %q", tt.path, shell.QuotePath(tt.path), out, tt.want)
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(home, "pwned")); err == nil {
+		t.Error("QuotePath ran injected command")
+	}
+}
+
+func TestCmd(t *testing.T) {
+	c := shell.Command("printf", `%s\n`, "a b").Then("&&", shell.Command("printf", `%s\n`).Path("~/c d"))
+	want := `printf '%s\n' 'a b' && printf '%s\n' ~/'c d'`
+	if c.String() != want {
+		t.Errorf("got %s, want %s", c.String(), want)
+	}
+}
@@ -0,0 +1,267 @@
+package sshconfig
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Host holds connection settings of a host alias, empty when not specified
+type Host struct {
+	HostName      string
+	Port          string
+	User          string
+	IdentityFiles []string
+	ProxyJump     string
+}
+
+// block is settings of a "Host" line, settings before any "Host" line apply to all hosts
+type block struct {
+	patterns []string
+	// match blocks are not supported & never apply
+	match    bool
+	settings [][2]string
+}
+
+// Config is parsed ssh client config, like ~/.ssh/config
+type Config struct {
+	blocks []*block
+}
+
+// maxIncludeDepth stops include loops
+const maxIncludeDepth = 8
+
+// DefaultPath returns path of user ssh config
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "config")
+}
+
+// Load parses ssh config at @path, missing file is an empty config
+func Load(path string) (*Config, error) {
+	c := &Config{blocks: []*block{{patterns: []string{"*"}}}}
+	if path == "" {
+		return c, nil
+	}
+
+	err := c.parseFile(path, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	return c, err
+}
+
+func (c *Config) parseFile(path string, depth int) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		keyword, args := splitLine(scanner.Text())
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			c.blocks = append(c.blocks, &block{patterns: args})
+		case "match":
+			c.blocks = append(c.blocks, &block{match: true})
+		case "include":
+			if depth >= maxIncludeDepth {
+				return errors.New("too deeply nested includes in " + path)
+			}
+			for _, inc := range args {
+				if err = c.include(inc, depth+1); err != nil {
+					return err
+				}
+			}
+		default:
+			if len(args) > 0 {
+				last := c.blocks[len(c.blocks)-1]
+				last.settings = append(last.settings, [2]string{keyword, strings.Join(args, " ")})
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// include parses files matching @pattern, relative ones are in ~/.ssh
+func (c *Config) include(pattern string, depth int) error {
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(DefaultPath()), pattern)
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err = c.parseFile(f, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitLine splits config line to lowercase keyword & args, which can be quoted
+func splitLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+
+	// keyword is separated by whitespace or "="
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var (
+		args   []string
+		cur    strings.Builder
+		quoted bool
+	)
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case (r == ' ' || r == '\t') && !quoted:
+			if cur.Len() > 0 {
+				args = append(args, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		args = append(args, cur.String())
+	}
+	return keyword, args
+}
+
+// Lookup returns settings of @alias, first obtained value of each setting is used like ssh does
+func (c *Config) Lookup(alias string) Host {
+	h := Host{}
+	for _, b := range c.blocks {
+		if !b.matches(alias) {
+			continue
+		}
+
+		for _, s := range b.settings {
+			switch s[0] {
+			case "hostname":
+				if h.HostName == "" {
+					h.HostName = strings.ReplaceAll(s[1], "%h", alias)
+				}
+			case "port":
+				if h.Port == "" {
+					h.Port = s[1]
+				}
+			case "user":
+				if h.User == "" {
+					h.User = s[1]
+				}
+			case "identityfile":
+				h.IdentityFiles = append(h.IdentityFiles, expandHome(strings.ReplaceAll(s[1], "%h", alias)))
+			case "proxyjump":
+				if h.ProxyJump == "" {
+					h.ProxyJump = s[1]
+				}
+			}
+		}
+	}
+	return h
+}
+
+// matches reports whether any pattern of the block matches @alias & no negated one does
+func (b *block) matches(alias string) bool {
+	if b.match {
+		return false
+	}
+
+	matched := false
+	for _, p := range b.patterns {
+		if negated := strings.HasPrefix(p, "!"); negated {
+			if wildcardMatch(p[1:], alias) {
+				return false
+			}
+			continue
+		}
+		if wildcardMatch(p, alias) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// wildcardMatch matches @s by @pattern which can contain * & ?
+func wildcardMatch(pattern, s string) bool {
+	re := "^" + strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(regexp.QuoteMeta(pattern)) + "$"
+	ok, _ := regexp.MatchString(re, s)
+	return ok
+}
+
+// expandHome expands leading ~ & %d to home dir
+func expandHome(p string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+
+	p = strings.ReplaceAll(p, "%d", home)
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		p = filepath.Join(home, p[1:])
+	}
+	return p
+}
+
+// Jump is a hop of ProxyJump like "user@host:port", empty fields are not specified
+type Jump struct {
+	User string
+	Host string
+	Port string
+}
+
+// Jumps parses ProxyJump of the host, "none" means no jumps
+func (h Host) Jumps() []Jump {
+	if h.ProxyJump == "" || strings.EqualFold(h.ProxyJump, "none") {
+		return nil
+	}
+
+	var jumps []Jump
+	for _, hop := range strings.Split(h.ProxyJump, ",") {
+		hop = strings.TrimPrefix(strings.TrimSpace(hop), "ssh://")
+		if hop == "" {
+			continue
+		}
+
+		j := Jump{Host: hop}
+		if at := strings.LastIndex(hop, "@"); at >= 0 {
+			j.User, j.Host = hop[:at], hop[at+1:]
+		}
+		// host:port, [ipv6]:port
+		if strings.HasPrefix(j.Host, "[") || strings.Count(j.Host, ":") == 1 {
+			if host, port, err := net.SplitHostPort(j.Host); err == nil {
+				j.Host, j.Port = host, port
+			}
+		}
+		jumps = append(jumps, j)
+	}
+	return jumps
+}
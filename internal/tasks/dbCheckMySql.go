@@ -0,0 +1,38 @@
+package tasks
+
+import (
+	"errors"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/shell"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+// DbCheckMySql checks the project DB can be dumped with its DB info, by dumping nothing from it
+func DbCheckMySql(c *ssh.Client, pc *config.ProjectConfig) error {
+	out, err := New(DbCheckMySqlCmd(pc, false)).Execute(c)
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return errors.New("DB " + pc.DbInfo.Name + " is not accessible. " + msg)
+	}
+	return nil
+}
+
+// DbCheckMySqlCmd returns the remote command DbCheckMySql runs.
+// DB password is replaced by a mask when @maskSecrets is true (for printing)
+func DbCheckMySqlCmd(pc *config.ProjectConfig, maskSecrets bool) string {
+	pass := pc.DbInfo.Pass
+	if maskSecrets {
+		pass = SecretMask
+	}
+
+	return shell.Command("mysqldump").
+		Arg(mysqlLogin(pc, pass)...).
+		Op("--no-data --no-create-info --skip-lock-tables --").
+		Arg(pc.DbInfo.Name).
+		Op("> /dev/null").
+		String()
+}
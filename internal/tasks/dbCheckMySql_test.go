@@ -0,0 +1,29 @@
+package tasks_test
+
+import (
+	"github.com/apudiu/server-backup/internal/tasks"
+	"reflect"
+	"testing"
+)
+
+func TestDbCheckMySqlCmd(t *testing.T) {
+	for _, h := range hostile {
+		t.Run(h.name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, pc := dumpConfig(dir)
+			pc.DbInfo.Name = h.value
+
+			// output of the check is discarded, args are printed to stderr
+			toStderr := `mysqldump() { printf "%s\n" mysqldump "$@" >&2; }` + "\n"
+			got := args(t, dir, toStderr+tasks.DbCheckMySqlCmd(pc, false))
+			want := []string{
+				"mysqldump", "-h10.0.0.1", "-P3307", "-uuser", "-ppass",
+				"--no-data", "--no-create-info", "--skip-lock-tables", "--", h.value,
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %q\nwant %q", got, want)
+			}
+			checkNotInjected(t, dir)
+		})
+	}
+}
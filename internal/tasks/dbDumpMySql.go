@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"github.com/apudiu/server-backup/internal/config"
 	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/shell"
 	"github.com/apudiu/server-backup/internal/util"
 	"golang.org/x/crypto/ssh"
-	"strings"
+	"io"
+	"strconv"
 )
 
 func DbDumpMySql(
@@ -16,31 +18,8 @@ func DbDumpMySql(
 	l *logger.Logger,
 	dumpFilePath string,
 ) (t *Task, err error) {
-	srcDir := pc.SourcePath(sc)
-
-	cmdOptions := fmt.Sprintf(
-		`-e -h"%v" -u"%v" -p"%v" --add-drop-table`,
-		pc.DbInfo.Host, pc.DbInfo.User, pc.DbInfo.Pass,
-	)
-
-	cmd := []string{
-		// go to parent dir of the dir need to be zipped
-		"cd",
-		srcDir + util.DS + "..",
-		"&&",
-
-		// zip the target dir
-		"mysqldump",
-		cmdOptions,
-		pc.DbInfo.Name,
-		"|",
-		"gzip -9",
-		">",
-		dumpFilePath,
-	}
-
 	// create task for execution
-	t = New(strings.Join(cmd, " "))
+	t = New(DbDumpMySqlCmd(sc, pc, dumpFilePath, false))
 	start, wait, closeFn, err := t.ExecuteLive(c)
 	if err != nil {
 		err = util.ErrWithPrefix("DB dump task error for "+c.RemoteAddr().String(), err)
@@ -48,10 +27,42 @@ func DbDumpMySql(
 	}
 	defer closeFn()
 
-	// read output in realtime
+	err = runDbDump(t, start, wait, sc, pc, l)
+	return
+}
+
+// DbDumpMySqlStream dumps DB of the project writing gzipped dump to @w as it's made,
+// no dump file is kept in the server
+func DbDumpMySqlStream(
+	c *ssh.Client,
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	l *logger.Logger,
+	w io.Writer,
+) (t *Task, err error) {
+	t = New(DbDumpMySqlCmd(sc, pc, Stdout, false))
+	start, wait, closeFn, err := t.ExecuteStream(c, w)
+	if err != nil {
+		err = util.ErrWithPrefix("DB dump task error for "+c.RemoteAddr().String(), err)
+		return
+	}
+	defer closeFn()
+
+	err = runDbDump(t, start, wait, sc, pc, l)
+	return
+}
 
+// runDbDump runs DB dump task @t logging its output
+func runDbDump(
+	t *Task,
+	start, wait func() error,
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	l *logger.Logger,
+) error {
+	// read output in realtime
 	l.AddHeader(
-		fmt.Sprintf("Dumping %s from %s:%s", pc.DbInfo.Name, sc.Ip.String(), sc.ProjectRoot+util.DS+pc.Path),
+		fmt.Sprintf("Dumping %s from %s:%s", pc.DbInfo.Name, sc.Id(), sc.ProjectRoot+util.DS+pc.Path),
 	)
 
 	ch := make(chan struct{})
@@ -61,11 +72,56 @@ func DbDumpMySql(
 	}()
 
 	// wait to copy all output
-	err = start()
+	if err := start(); err != nil {
+		return err
+	}
 	<-ch
 
 	// wait to finish the task
-	err = wait()
+	return wait()
+}
 
-	return
+// DbDumpMySqlCmd returns the remote command DbDumpMySql runs, dump is written to stdout when @dumpFilePath
+// is Stdout. DB password is replaced by a mask when @maskSecrets is true (for printing)
+func DbDumpMySqlCmd(
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	dumpFilePath string,
+	maskSecrets bool,
+) string {
+	srcDir := pc.SourcePath(sc)
+
+	pass := pc.DbInfo.Pass
+	if maskSecrets {
+		pass = SecretMask
+	}
+
+	// go to parent dir of the project
+	cmd := shell.Command("cd").Op("--").Path(srcDir + util.DS + "..")
+
+	// dump the db & compress it
+	dump := shell.Command("mysqldump").Op("-e").Arg(mysqlLogin(pc, pass)...).Op("--add-drop-table --").Arg(pc.DbInfo.Name)
+	gzip := shell.Command("gzip").Op("-9")
+	if dumpFilePath != Stdout {
+		gzip.Op(">").Path(dumpFilePath)
+	} else {
+		gzip.Op(">&4")
+	}
+
+	// a pipeline exits with status of gzip, which succeeds on a truncated dump too. POSIX shells have no pipefail,
+	// so status of mysqldump is passed out by fd 3 & the command exits with it, gzip output goes to stdout by fd 4
+	return cmd.Then("&& exec 4>&1 && s=$({ {", dump).
+		Op("; echo $? >&3; }").Then("|", gzip).
+		Op(`; } 3>&1) && exit "$s"`).
+		String()
+}
+
+// mysqlLogin returns login options of mysql client tools for the project DB, using @pass as password.
+// Port is left to the client default when not known
+func mysqlLogin(pc *config.ProjectConfig, pass string) []string {
+	opts := []string{"-h" + fmt.Sprint(pc.DbInfo.Host)}
+	if pc.DbInfo.Port != 0 {
+		opts = append(opts, "-P"+strconv.Itoa(pc.DbInfo.Port))
+	}
+	return append(opts, "-u"+pc.DbInfo.User, "-p"+pass)
 }
@@ -0,0 +1,85 @@
+package tasks_test
+
+import (
+	"errors"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/tasks"
+	"net"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func dumpConfig(root string) (*config.ServerConfig, *config.ProjectConfig) {
+	sc := &config.ServerConfig{ProjectRoot: root}
+	pc := &config.ProjectConfig{Path: "app"}
+	pc.DbInfo.Host = net.ParseIP("10.0.0.1")
+	pc.DbInfo.Port = 3307
+	pc.DbInfo.User = "user"
+	pc.DbInfo.Pass = "pass"
+	pc.DbInfo.Name = "db"
+	return sc, pc
+}
+
+func TestDbDumpMySqlCmdFailsWithMysqldump(t *testing.T) {
+	dir := t.TempDir()
+	sc, pc := dumpConfig(dir)
+	if err := os.MkdirAll(pc.SourcePath(sc), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// dump fails halfway, gzip still gets & compresses the partial output
+	failing := stubs + `mysqldump() { printf "%s\n" "-- partial"; return 3; }` + "\n"
+
+	for _, dest := range []string{tasks.Stdout, dir + "/dump.sql.gz"} {
+		out, err := run(t, dir, failing+tasks.DbDumpMySqlCmd(sc, pc, dest, false))
+
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+			t.Errorf("dump to %s: got error %v, want exit status 3 of mysqldump, output: %q", dest, err, out)
+		}
+	}
+}
+
+func TestDbDumpMySqlCmd(t *testing.T) {
+	for _, h := range hostile {
+		t.Run(h.name, func(t *testing.T) {
+			dir := t.TempDir()
+			name := fileName(h.value)
+			sc, pc := dumpConfig(dir + "/root " + name)
+			pc.Path = name
+			pc.DbInfo.User = "user " + h.value
+			pc.DbInfo.Pass = `p"'$` + h.value
+			pc.DbInfo.Name = h.value
+			srcDir := pc.SourcePath(sc)
+			if err := os.MkdirAll(srcDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			got := args(t, dir, tasks.DbDumpMySqlCmd(sc, pc, tasks.Stdout, false))
+			want := []string{
+				"cd", "--", srcDir + "/..",
+				"mysqldump", "-e", "-h10.0.0.1", "-P3307", "-u" + pc.DbInfo.User, "-p" + pc.DbInfo.Pass,
+				"--add-drop-table", "--", h.value,
+				"gzip", "-9",
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %q\nwant %q", got, want)
+			}
+
+			// dump file is written by redirection, not passed as arg
+			dumpPath := sc.ProjectRoot + "/d " + name + ".sql.gz"
+			args(t, dir, tasks.DbDumpMySqlCmd(sc, pc, dumpPath, false))
+			b, err := os.ReadFile(dumpPath)
+			if err != nil {
+				t.Fatalf("dump file: %v", err)
+			}
+			if want := strings.Join(want[3:], "\n") + "\n"; string(b) != want {
+				t.Errorf("dump file has %q, want %q", b, want)
+			}
+			checkNotInjected(t, dir)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package tasks
+
+import (
+	"fmt"
+	"github.com/apudiu/server-backup/internal/config"
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/shell"
+	"github.com/apudiu/server-backup/internal/util"
+	"golang.org/x/crypto/ssh"
+)
+
+// DbImportMySql imports a gzipped dump made by DbDumpMySql into the project DB
+func DbImportMySql(
+	c *ssh.Client,
+	sc *config.ServerConfig,
+	pc *config.ProjectConfig,
+	l *logger.Logger,
+	dumpFilePath string,
+) (t *Task, err error) {
+	// create task for execution
+	t = New(DbImportMySqlCmd(pc, dumpFilePath))
+	start, wait, closeFn, err := t.ExecuteLive(c)
+	if err != nil {
+		err = util.ErrWithPrefix("DB import task error for "+c.RemoteAddr().String(), err)
+		return
+	}
+	defer closeFn()
+
+	// read output in realtime
+
+	l.AddHeader(
+		fmt.Sprintf("Importing %s into %s on %s", dumpFilePath, pc.DbInfo.Name, sc.Id()),
+	)
+
+	ch := make(chan struct{})
+	go func() {
+		l.ReadStream(&t.StdOutErr)
+		ch <- struct{}{}
+	}()
+
+	// wait to copy all output
+	if err = start(); err != nil {
+		return
+	}
+	<-ch
+
+	// wait to finish the task
+	err = wait()
+
+	return
+}
+
+// DbImportMySqlCmd returns the remote command DbImportMySql runs
+func DbImportMySqlCmd(pc *config.ProjectConfig, dumpFilePath string) string {
+	// decompress the dump
+	cmd := shell.Command("gunzip").Op("-c --").Path(dumpFilePath)
+
+	// feed it to the db
+	mysql := shell.Command("mysql").Arg(mysqlLogin(pc, pc.DbInfo.Pass)...).Op("--").Arg(pc.DbInfo.Name)
+
+	return cmd.Then("|", mysql).String()
+}
@@ -0,0 +1,28 @@
+package tasks_test
+
+import (
+	"github.com/apudiu/server-backup/internal/tasks"
+	"reflect"
+	"testing"
+)
+
+func TestDbImportMySqlCmd(t *testing.T) {
+	for _, h := range hostile {
+		t.Run(h.name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, pc := dumpConfig(dir)
+			pc.DbInfo.Name = h.value
+			dumpPath := fileName(h.value) + ".sql.gz"
+
+			got := args(t, dir, tasks.DbImportMySqlCmd(pc, dumpPath))
+			want := []string{
+				"gunzip", "-c", "--", operand(dumpPath),
+				"mysql", "-h10.0.0.1", "-P3307", "-uuser", "-ppass", "--", h.value,
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %q\nwant %q", got, want)
+			}
+			checkNotInjected(t, dir)
+		})
+	}
+}
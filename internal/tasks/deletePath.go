@@ -1,19 +1,19 @@
 package tasks
 
 import (
+	"github.com/apudiu/server-backup/internal/shell"
 	"golang.org/x/crypto/ssh"
-	"strings"
 )
 
 // DeletePath deletes remote path
 func DeletePath(c *ssh.Client, path string) (result []byte, err error) {
-	cmd := []string{
-		"rm -rf",
-		path,
-	}
-
 	// create task for execution
-	t := New(strings.Join(cmd, " "))
+	t := New(DeletePathCmd(path))
 	result, err = t.Execute(c)
 	return
 }
+
+// DeletePathCmd returns the remote command DeletePath runs
+func DeletePathCmd(path string) string {
+	return shell.Command("rm").Op("-rf --").Path(path).String()
+}
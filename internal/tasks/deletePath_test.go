@@ -0,0 +1,23 @@
+package tasks_test
+
+import (
+	"github.com/apudiu/server-backup/internal/tasks"
+	"reflect"
+	"testing"
+)
+
+func TestDeletePathCmd(t *testing.T) {
+	for _, h := range hostile {
+		t.Run(h.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := fileName(h.value)
+
+			got := args(t, dir, tasks.DeletePathCmd(path))
+			want := []string{"rm", "-rf", "--", operand(path)}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %q\nwant %q", got, want)
+			}
+			checkNotInjected(t, dir)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/shell"
+	"github.com/apudiu/server-backup/internal/util"
+	"golang.org/x/crypto/ssh"
+	"regexp"
+	"strings"
+)
+
+// ErrNoSha256Tool is returned when the server has neither sha256sum nor shasum
+var ErrNoSha256Tool = errors.New("neither sha256sum nor shasum is available in the server")
+
+// exitNotFound is exit status of FileSha256Cmd when no hashing tool is available, like shells do
+const exitNotFound = 127
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// FileSha256 returns hex SHA-256 of remote file at @path
+func FileSha256(c *ssh.Client, path string) (string, error) {
+	t := New(FileSha256Cmd(path))
+	out, err := t.Execute(c)
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitStatus() == exitNotFound {
+		return "", ErrNoSha256Tool
+	}
+	if err != nil {
+		return "", util.ErrWithPrefix("Failed to hash "+c.RemoteAddr().String()+":"+path, err)
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 || !sha256Hex.MatchString(fields[0]) {
+		return "", fmt.Errorf("unexpected sha256 output for %s: %q", path, strings.TrimSpace(string(out)))
+	}
+	return fields[0], nil
+}
+
+// FileSha256Cmd returns the remote command FileSha256 runs, sha256sum is used or shasum when missing
+func FileSha256Cmd(path string) string {
+	return shell.Op("if command -v sha256sum >/dev/null 2>&1; then sha256sum --").Path(path).
+		Op("; elif command -v shasum >/dev/null 2>&1; then shasum -a 256 --").Path(path).
+		Op(fmt.Sprintf("; else exit %d; fi", exitNotFound)).
+		String()
+}
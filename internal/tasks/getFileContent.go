@@ -1,20 +1,15 @@
 package tasks
 
 import (
+	"github.com/apudiu/server-backup/internal/shell"
 	"github.com/apudiu/server-backup/internal/util"
 	"golang.org/x/crypto/ssh"
-	"strings"
 )
 
 // GetFileContent gets the file content from remote
 func GetFileContent(c *ssh.Client, filePath string) (contents []byte, err error) {
-	cmd := []string{
-		"cat",
-		filePath,
-	}
-
 	// create task for execution
-	t := New(strings.Join(cmd, " "))
+	t := New(GetFileContentCmd(filePath))
 	contents, err = t.Execute(c)
 	if err != nil {
 		err = util.ErrWithPrefix("Error getting file content for "+c.RemoteAddr().String()+":"+filePath, err)
@@ -22,3 +17,8 @@ func GetFileContent(c *ssh.Client, filePath string) (contents []byte, err error)
 
 	return
 }
+
+// GetFileContentCmd returns the remote command GetFileContent runs
+func GetFileContentCmd(filePath string) string {
+	return shell.Command("cat").Op("--").Path(filePath).String()
+}
@@ -0,0 +1,34 @@
+package tasks_test
+
+import (
+	"github.com/apudiu/server-backup/internal/tasks"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetFileContentCmd(t *testing.T) {
+	for _, h := range hostile {
+		t.Run(h.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := fileName(h.value)
+
+			got := args(t, dir, tasks.GetFileContentCmd(path))
+			want := []string{"cat", "--", operand(path)}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %q\nwant %q", got, want)
+			}
+
+			// real cat reads the file, even when its name looks like an option
+			if err := os.WriteFile(filepath.Join(dir, path), []byte("content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			out, err := run(t, dir, tasks.GetFileContentCmd(path))
+			if err != nil || out != "content" {
+				t.Errorf("cat: got %q, %v, want file content", out, err)
+			}
+			checkNotInjected(t, dir)
+		})
+	}
+}
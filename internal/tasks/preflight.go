@@ -0,0 +1,102 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apudiu/server-backup/internal/shell"
+	"github.com/apudiu/server-backup/internal/util"
+	"golang.org/x/crypto/ssh"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrToolMissing is returned when a required command is not installed in the server
+var ErrToolMissing = errors.New("not installed")
+
+var versionNumber = regexp.MustCompile(`\d+\.\d+(\.\d+)?`)
+
+// ToolVersion returns version of remote command @name, printed by running it with @versionArg.
+// Version is empty when it can't be found in the output
+func ToolVersion(c *ssh.Client, name, versionArg string) (string, error) {
+	t := New(ToolVersionCmd(name, versionArg))
+	out, err := t.Execute(c)
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitStatus() == exitNotFound {
+		return "", fmt.Errorf("%s is %w in the server", name, ErrToolMissing)
+	}
+	// some tools exit with non-zero status after printing version, output is enough then
+	if err != nil && len(out) == 0 {
+		return "", util.ErrWithPrefix("Failed to run "+name, err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if v := versionNumber.FindString(line); v != "" {
+			return v, nil
+		}
+	}
+	return "", nil
+}
+
+// ToolVersionCmd returns the remote command ToolVersion runs
+func ToolVersionCmd(name, versionArg string) string {
+	return shell.Command("command", "-v", name).Op(">/dev/null 2>&1 ||").
+		Op(fmt.Sprintf("exit %d;", exitNotFound)).
+		Arg(name, versionArg).Op("2>&1").
+		String()
+}
+
+// DiskUsage returns size in bytes of remote @path, like du
+func DiskUsage(c *ssh.Client, path string) (int64, error) {
+	out, err := New(DiskUsageCmd(path)).Execute(c)
+	if err != nil {
+		return 0, util.ErrWithPrefix("Failed to get size of "+path+". "+strings.TrimSpace(string(out)), err)
+	}
+	return parseKb(out)
+}
+
+// DiskUsageCmd returns the remote command DiskUsage runs
+func DiskUsageCmd(path string) string {
+	return shell.Command("du").Op("-sk --").Path(path).Op("| cut -f1").String()
+}
+
+// FreeSpace returns bytes available to the user in the file system of remote @path, like df
+func FreeSpace(c *ssh.Client, path string) (int64, error) {
+	out, err := New(FreeSpaceCmd(path)).Execute(c)
+	if err != nil {
+		return 0, util.ErrWithPrefix("Failed to get free space of "+path+". "+strings.TrimSpace(string(out)), err)
+	}
+	return parseKb(out)
+}
+
+// FreeSpaceCmd returns the remote command FreeSpace runs
+func FreeSpaceCmd(path string) string {
+	return shell.Command("df").Op("-Pk --").Path(path).Op("| tail -n 1 | awk '{print $4}'").String()
+}
+
+// CheckWritable checks remote dir @path is writable by the user
+func CheckWritable(c *ssh.Client, path string) error {
+	_, err := New(CheckWritableCmd(path)).Execute(c)
+	if err != nil {
+		return errors.New(path + " is not a writable dir")
+	}
+	return nil
+}
+
+// CheckWritableCmd returns the remote command CheckWritable runs. test doesn't know "--", it takes a path starting
+// with "-" as operand after -d anyway & Path keeps it from being an option
+func CheckWritableCmd(path string) string {
+	return shell.Command("test").Op("-d").Path(path).
+		Then("&&", shell.Command("test").Op("-w").Path(path)).
+		String()
+}
+
+// parseKb parses kilobytes count printed by du or df to bytes
+func parseKb(out []byte) (int64, error) {
+	kb, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected size output %q", strings.TrimSpace(string(out)))
+	}
+	return kb * 1024, nil
+}
@@ -0,0 +1,49 @@
+package tasks_test
+
+import (
+	"github.com/apudiu/server-backup/internal/tasks"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiskUsageCmd(t *testing.T) {
+	for _, h := range hostile {
+		t.Run(h.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := fileName(h.value)
+
+			got := args(t, dir, tasks.DiskUsageCmd(path))
+			want := []string{"du", "-sk", "--", operand(path), "cut", "-f1"}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %q\nwant %q", got, want)
+			}
+			checkNotInjected(t, dir)
+		})
+	}
+}
+
+func TestCheckWritableCmd(t *testing.T) {
+	for _, h := range hostile {
+		t.Run(h.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := fileName(h.value)
+
+			got := args(t, dir, tasks.CheckWritableCmd(path))
+			want := []string{"test", "-d", operand(path), "test", "-w", operand(path)}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %q\nwant %q", got, want)
+			}
+
+			// real test checks the dir, even when its name looks like an option
+			if err := os.Mkdir(filepath.Join(dir, path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if out, err := run(t, dir, tasks.CheckWritableCmd(path)); err != nil {
+				t.Errorf("test: %v, output: %q, want writable dir", err, out)
+			}
+			checkNotInjected(t, dir)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package tasks
+
+import (
+	"github.com/apudiu/server-backup/internal/util"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+// SystemInfo returns login shell & OS of the server, like "/bin/bash" & "Ubuntu 22.04.3 LTS (Linux 5.15.0 x86_64)"
+func SystemInfo(c *ssh.Client) (shell, os string, err error) {
+	out, err := New(SystemInfoCmd()).Execute(c)
+	if err != nil {
+		return "", "", util.ErrWithPrefix("Failed to get system info. "+strings.TrimSpace(string(out)), err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	for len(lines) < 3 {
+		lines = append(lines, "")
+	}
+	shell, kernel, distro := strings.TrimSpace(lines[0]), strings.TrimSpace(lines[1]), strings.TrimSpace(lines[2])
+
+	os = kernel
+	if distro != "" {
+		os = distro + " (" + kernel + ")"
+	}
+	return shell, os, nil
+}
+
+// SystemInfoCmd returns the remote command SystemInfo runs, it prints shell, kernel & distro name in separate lines
+func SystemInfoCmd() string {
+	cmd := []string{
+		`echo "$SHELL";`,
+		"uname -srm;",
+		`(. /etc/os-release 2>/dev/null && echo "$PRETTY_NAME")`,
+		"|| true",
+	}
+
+	return strings.Join(cmd, " ")
+}
@@ -6,9 +6,16 @@ import (
 	"io"
 )
 
+// SecretMask replaces secrets in printed commands
+const SecretMask = "****"
+
+// Stdout as dest path of zip or db dump writes it to command output, for streaming without remote temp file
+const Stdout = "-"
+
 type ServerTask interface {
 	Execute(serverConn *ssh.Client) (result []byte, err error)
 	ExecuteLive(serverConn *ssh.Client) (start, wait, closeFn func() error, err error)
+	ExecuteStream(serverConn *ssh.Client, w io.Writer) (start, wait, closeFn func() error, err error)
 }
 
 type Task struct {
@@ -28,6 +35,12 @@ func (t *Task) ExecuteLive(c *ssh.Client) (start, wait, closeFn func() error, er
 	return
 }
 
+// ExecuteStream runs the task writing its output to @w, StdOutErr is stdErr only then
+func (t *Task) ExecuteStream(c *ssh.Client, w io.Writer) (start, wait, closeFn func() error, err error) {
+	start, wait, closeFn, err = server.ExecCmdStream(c, t.Command, w, &t.StdOutErr)
+	return
+}
+
 func New(command string) *Task {
 	t := &Task{
 		Command: command,
@@ -0,0 +1,89 @@
+package tasks_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// hostile values of paths & DB info, each must reach the command as a single unchanged operand, never as an option
+var hostile = []struct {
+	name, value string
+}{
+	{"plain", "plain"},
+	{"space", "with space"},
+	{"single quote", "it's"},
+	{"double quote", `say "hi"`},
+	{"dollar", "$HOME"},
+	{"command substitution", "$(touch pwned)"},
+	{"backtick", "`touch pwned`"},
+	{"semicolon", "a; touch pwned"},
+	{"glob", "*"},
+	{"leading dash", "-rf"},
+	{"long option", "--result-file=pwned"},
+	{"backslash", `a\b`},
+	{"bang", "!x"},
+}
+
+// stubs replaces commands run by tasks with shell functions printing their name & args, one per line.
+// Commands reading a pipe pass their input through first, so args of the whole pipeline are printed
+const stubs = `
+pass() { while IFS= read -r l; do printf "%s\n" "$l"; done; }
+cd() { printf "%s\n" cd "$@"; }
+zip() { printf "%s\n" zip "$@"; }
+rm() { printf "%s\n" rm "$@"; }
+cat() { printf "%s\n" cat "$@"; }
+test() { printf "%s\n" test "$@"; }
+du() { printf "%s\n" du "$@"; }
+cut() { pass; printf "%s\n" cut "$@"; }
+gunzip() { printf "%s\n" gunzip "$@"; }
+mysqldump() { printf "%s\n" mysqldump "$@"; }
+mysql() { pass; printf "%s\n" mysql "$@"; }
+gzip() { pass; printf "%s\n" gzip "$@"; }
+`
+
+// run runs @script by sh in @dir, returns its output & error. Skipped where sh is unavailable
+func run(t *testing.T, dir, script string) (string, error) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is unavailable")
+	}
+
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// args runs remote command @cmd with stubbed commands & returns printed args, fails @t on error
+func args(t *testing.T, dir, cmd string) []string {
+	t.Helper()
+	out, err := run(t, dir, stubs+cmd)
+	if err != nil {
+		t.Fatalf("sh -c %q failed: %v, output: %q", cmd, err, out)
+	}
+	return strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+}
+
+// operand returns how path @p reaches commands, a leading "-" gets "./" prefix
+func operand(p string) string {
+	if strings.HasPrefix(p, "-") {
+		return "./" + p
+	}
+	return p
+}
+
+// fileName returns @value usable as a file name, it can't have "/"
+func fileName(value string) string {
+	return strings.ReplaceAll(value, "/", "_")
+}
+
+// checkNotInjected fails @t when a command injected by a hostile value was run in @dir
+func checkNotInjected(t *testing.T, dir string) {
+	t.Helper()
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Error("injected command was run")
+	}
+}
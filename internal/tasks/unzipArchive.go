@@ -0,0 +1,68 @@
+package tasks
+
+import (
+	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/shell"
+	"github.com/apudiu/server-backup/internal/util"
+	"golang.org/x/crypto/ssh"
+	"path/filepath"
+)
+
+// UnzipArchive extracts a zip made by ZipDirectory into @targetDir.
+// The archive holds a single top level dir (@srcBaseDir), its contents end up in @targetDir
+func UnzipArchive(
+	c *ssh.Client,
+	zipPath, targetDir, srcBaseDir string,
+	l *logger.Logger,
+) (t *Task, err error) {
+	targetParent := targetDir + util.DS + ".."
+	targetBase := filepath.Base(targetDir)
+
+	// make sure target exists & go to its parent
+	cmd := shell.Command("mkdir").Op("-p --").Path(targetDir).
+		Then("&&", shell.Command("cd").Op("--").Path(targetParent))
+
+	// unzip doesn't know "--", paths are kept from being options by Path
+	if targetBase == srcBaseDir {
+		// archive top dir matches target, extract in place
+		cmd.Then("&&", shell.Command("unzip").Op("-o").Path(zipPath))
+	} else {
+		// extract in a staging dir & move contents into target
+		stagingDir := ".restore-" + srcBaseDir
+		cmd.Then("&&", shell.Command("unzip").Op("-o").Path(zipPath).Op("-d").Arg(stagingDir)).
+			Then("&&", shell.Command("cp").Op("-a --").Path(stagingDir+util.DS+srcBaseDir+util.DS+".", targetBase+util.DS)).
+			Then("&&", shell.Command("rm").Op("-rf --").Arg(stagingDir))
+	}
+
+	// create task for execution
+	t = New(cmd.String())
+	start, wait, closeFn, err := t.ExecuteLive(c)
+	if err != nil {
+		err = util.ErrWithPrefix("UnzipArchive task error for "+c.RemoteAddr().String(), err)
+		return
+	}
+	defer closeFn()
+
+	// read output in realtime
+	l.AddHeader("Unzipping " + zipPath + " into " + targetDir)
+
+	// every file extracted is a line, those are logged at debug level & summarized
+	extracted := 0
+	ch := make(chan struct{})
+	go func() {
+		extracted = l.ReadStreamSummarized(&t.StdOutErr, "inflating:", "extracting:", "creating:")
+		ch <- struct{}{}
+	}()
+
+	// wait to copy all output
+	if err = start(); err != nil {
+		return
+	}
+	<-ch
+
+	// wait to finish the task
+	err = wait()
+	l.Info("Unzip done", logger.Fields{"files": extracted})
+
+	return
+}
@@ -1,12 +1,12 @@
 package tasks
 
 import (
-	"fmt"
 	"github.com/apudiu/server-backup/internal/logger"
+	"github.com/apudiu/server-backup/internal/shell"
 	"github.com/apudiu/server-backup/internal/util"
 	"golang.org/x/crypto/ssh"
+	"io"
 	"path/filepath"
-	"strings"
 )
 
 func ZipDirectory(
@@ -15,27 +15,8 @@ func ZipDirectory(
 	excludeList []string,
 	l *logger.Logger,
 ) (t *Task, err error) {
-	srcBaseDir := filepath.Base(sourceDir)
-
-	zipOptions := "-ry9"
-	excludeOptions := formatExclude(excludeList, srcBaseDir)
-
-	cmd := []string{
-		// go to parent dir of the dir need to be zipped
-		"cd",
-		sourceDir + util.DS + "..",
-		"&&",
-
-		// zip the target dir
-		"zip",
-		zipOptions,
-		destZipPath,
-		srcBaseDir,
-		excludeOptions,
-	}
-
 	// create task for execution
-	t = New(strings.Join(cmd, " "))
+	t = New(ZipDirectoryCmd(sourceDir, destZipPath, excludeList))
 	start, wait, closeFn, err := t.ExecuteLive(c)
 	if err != nil {
 		err = util.ErrWithPrefix("ZipDirectory task error for "+c.RemoteAddr().String(), err)
@@ -43,41 +24,78 @@ func ZipDirectory(
 	}
 	defer closeFn()
 
+	err = runZip(t, start, wait, l)
+	return
+}
+
+// ZipDirectoryStream zips @sourceDir writing the zip to @w as it's made, no zip file is kept in the server
+func ZipDirectoryStream(
+	c *ssh.Client,
+	sourceDir string,
+	excludeList []string,
+	w io.Writer,
+	l *logger.Logger,
+) (t *Task, err error) {
+	t = New(ZipDirectoryCmd(sourceDir, Stdout, excludeList))
+	start, wait, closeFn, err := t.ExecuteStream(c, w)
+	if err != nil {
+		err = util.ErrWithPrefix("ZipDirectory task error for "+c.RemoteAddr().String(), err)
+		return
+	}
+	defer closeFn()
+
+	err = runZip(t, start, wait, l)
+	return
+}
+
+// runZip runs zip task @t logging its output
+func runZip(t *Task, start, wait func() error, l *logger.Logger) error {
 	// read output in realtime
 	l.AddHeader("Zipping")
 
+	// every file zipped is a line, those are logged at debug level & summarized
+	zipped := 0
 	ch := make(chan struct{})
 	go func() {
-		l.ReadStream(&t.StdOutErr)
+		zipped = l.ReadStreamSummarized(&t.StdOutErr, "adding:")
 		ch <- struct{}{}
 	}()
 
 	// wait to copy all output
-	err = start()
+	if err := start(); err != nil {
+		return err
+	}
 	<-ch
 
 	// wait to finish the task
-	err = wait()
-
-	// put all outputs to the file
-	return
+	err := wait()
+	l.Info("Zip done", logger.Fields{"files": zipped})
+	return err
 }
 
-func formatExclude(l []string, prefixPath string) string {
-	if l == nil {
-		return ""
-	}
-
-	lLen := len(l) - 1
+// ZipDirectoryCmd returns the remote command ZipDirectory runs, zip is written to stdout when @destZipPath is Stdout
+func ZipDirectoryCmd(sourceDir, destZipPath string, excludeList []string) string {
+	srcBaseDir := filepath.Base(sourceDir)
 
-	r := `-x `
-	for i, p := range l {
-		r += fmt.Sprintf(`"%s%s%s"`, prefixPath, util.DS, p)
+	// go to parent dir of the dir need to be zipped
+	cmd := shell.Command("cd").Op("--").Path(sourceDir + util.DS + "..")
 
-		// add space after each except last one
-		if i < lLen {
-			r += " "
+	// zip the target dir. zip takes "--" only after the archive & ends the exclude list by it,
+	// so all operands are kept from being options by Path, zip matches excludes with "./" prefix too
+	zip := shell.Command("zip").Op("-ry9")
+	if destZipPath == Stdout {
+		zip.Op(Stdout)
+	} else {
+		zip.Path(destZipPath)
+	}
+	zip.Path(srcBaseDir)
+	if len(excludeList) > 0 {
+		// patterns are matched by zip, quoting keeps the shell from expanding those
+		zip.Op("-x")
+		for _, p := range excludeList {
+			zip.Path(srcBaseDir + util.DS + p)
 		}
 	}
-	return r
+
+	return cmd.Then("&&", zip).String()
 }
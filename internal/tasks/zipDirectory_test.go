@@ -0,0 +1,35 @@
+package tasks_test
+
+import (
+	"github.com/apudiu/server-backup/internal/tasks"
+	"reflect"
+	"testing"
+)
+
+func TestZipDirectoryCmd(t *testing.T) {
+	for _, h := range hostile {
+		t.Run(h.name, func(t *testing.T) {
+			dir := t.TempDir()
+			name := fileName(h.value)
+			srcDir := dir + "/root " + name + "/" + name
+			zipPath := name + ".zip"
+
+			got := args(t, dir, tasks.ZipDirectoryCmd(srcDir, zipPath, []string{"cache/*", name + "/*"}))
+			want := []string{
+				"cd", "--", srcDir + "/..",
+				"zip", "-ry9", operand(zipPath), operand(name),
+				"-x", operand(name + "/cache/*"), operand(name + "/" + name + "/*"),
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %q\nwant %q", got, want)
+			}
+
+			got = args(t, dir, tasks.ZipDirectoryCmd(srcDir, tasks.Stdout, nil))
+			want = []string{"cd", "--", srcDir + "/..", "zip", "-ry9", "-", operand(name)}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("stream: got  %q\nwant %q", got, want)
+			}
+			checkNotInjected(t, dir)
+		})
+	}
+}
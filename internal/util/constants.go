@@ -3,16 +3,50 @@ package util
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 var Eol = fmt.Sprintln()
 
+// Version of the tool, set at build time by -ldflags "-X github.com/apudiu/server-backup/internal/util.Version=..."
+var Version = "dev"
+
 const (
-	DS              = string(os.PathSeparator)
+	DS = string(os.PathSeparator)
+	// BackupCopies default backup copies to keep if not specified
+	BackupCopies = 3
+	// PartSuffix is added to names of artifacts being copied, a copy replaces the artifact only when complete
+	PartSuffix = ".part"
+	// PartMetaSuffix is added to names of part files to keep info for resuming those
+	PartMetaSuffix = ".meta"
+)
+
+// following paths can be changed by cli flags, see SetConfigDir & SetBackupDir
+var (
 	ConfigDir       = "." + DS + "config"
 	ServerConfigFle = ConfigDir + DS + "servers.yml"
 	BackupDir       = "." + DS + "backups"
-	// BackupCopies default backup copies to keep if not specified
-	BackupCopies = 3
-	ConfigGenArg = "gen"
 )
+
+// SetConfigDir changes config dir, servers config file is expected inside it
+func SetConfigDir(dir string) {
+	ConfigDir = trimTrailingDS(dir)
+	ServerConfigFle = ConfigDir + DS + "servers.yml"
+}
+
+// SetBackupDir changes default local backup dir
+func SetBackupDir(dir string) {
+	BackupDir = trimTrailingDS(dir)
+}
+
+// IsPartFile checks file @name is an incomplete copy or its resume info, those are never backup artifacts
+func IsPartFile(name string) bool {
+	return strings.HasSuffix(name, PartSuffix) || strings.HasSuffix(name, PartSuffix+PartMetaSuffix)
+}
+
+func trimTrailingDS(p string) string {
+	if t := strings.TrimRight(p, "/"+DS); t != "" {
+		return t
+	}
+	return p
+}
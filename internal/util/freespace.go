@@ -0,0 +1,14 @@
+//go:build !windows
+
+package util
+
+import "syscall"
+
+// FreeSpace returns bytes available to the user in the file system of local @path
+func FreeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
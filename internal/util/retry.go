@@ -0,0 +1,60 @@
+package util
+
+import (
+	"errors"
+	"time"
+)
+
+// maxRetryDelay caps the growing delay between retries
+const maxRetryDelay = 2 * time.Minute
+
+// Backoff retries a func with exponentially growing delay
+type Backoff struct {
+	// Attempts is total number of calls, including the first one
+	Attempts int
+	// Delay is wait before first retry, doubled after each
+	Delay time.Duration
+}
+
+// permanentErr stops retrying
+type permanentErr struct {
+	err error
+}
+
+func (e *permanentErr) Error() string { return e.err.Error() }
+func (e *permanentErr) Unwrap() error { return e.err }
+
+// Permanent marks @err as not worth retrying, like auth failures
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentErr{err: err}
+}
+
+// Retry calls @fn till it succeeds, returns a permanent error or attempts are done.
+// @onRetry (optional) is called with failed attempt number, its error & wait before next one.
+// Last error is returned, unwrapped when permanent
+func (b Backoff) Retry(fn func() error, onRetry func(attempt int, err error, wait time.Duration)) error {
+	delay := b.Delay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		var pe *permanentErr
+		if errors.As(err, &pe) {
+			return pe.err
+		}
+		if attempt >= b.Attempts {
+			return err
+		}
+
+		if onRetry != nil {
+			onRetry(attempt, err, delay)
+		}
+		time.Sleep(delay)
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
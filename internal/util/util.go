@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"github.com/fatih/color"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
@@ -206,23 +207,32 @@ func GetBytesForMb(mb int64) int64 {
 	return mb * 1024 * 1024
 }
 
-// GetCliArg returns arg value at @index.
-// @index is 0 based
-func GetCliArg(index uint8) (arg string, success bool) {
-	// access index
-	ai := index + 1
-
-	// args len
-	al := len(os.Args)
-
-	// if no args provided or
-	// index is out of range
-	if al < 2 || int(ai) >= al {
-		return
+// FormatBytes returns human-readable size like 1.5 MB
+func FormatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
 	}
 
-	success = true
-	arg = os.Args[ai]
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
 
-	return
+// PathSize returns size of a file or total size of files in a dir recursively, unreadable entries are ignored
+func PathSize(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, infoErr := d.Info(); infoErr == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
 }
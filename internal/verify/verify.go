@@ -0,0 +1,90 @@
+package verify
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DumpCompletedMarker is the trailer comment mysqldump writes when a dump finished successfully
+const DumpCompletedMarker = "-- Dump completed"
+
+// dumpTailSize is how many trailing bytes of a dump are searched for the marker
+const dumpTailSize = 4096
+
+// Zip opens the archive & reads every entry, so each entry's CRC is checked
+func Zip(path string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if len(r.File) == 0 {
+		return errors.New("archive has no entries")
+	}
+
+	for _, f := range r.File {
+		if err = zipEntry(f); err != nil {
+			return fmt.Errorf("entry %s: %w", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func zipEntry(f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// zip reader checks the CRC when entry is read till EOF
+	_, err = io.Copy(io.Discard, rc)
+	return err
+}
+
+// DbDump streams a gzipped mysqldump through gzip reader & checks for the completion trailer
+func DbDump(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tail := &tailWriter{size: dumpTailSize}
+	if _, err = io.Copy(tail, gz); err != nil {
+		return err
+	}
+
+	if !bytes.Contains(tail.buf, []byte(DumpCompletedMarker)) {
+		return errors.New("dump is incomplete, \"" + DumpCompletedMarker + "\" trailer missing")
+	}
+
+	return nil
+}
+
+// tailWriter keeps only the last @size bytes written to it
+type tailWriter struct {
+	size int
+	buf  []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.size; over > 0 {
+		t.buf = t.buf[over:]
+	}
+	return len(p), nil
+}